@@ -2,11 +2,14 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
 
+	"github.com/bongofriend/torrent-ingest/diskspace"
 	"github.com/bongofriend/torrent-ingest/models"
+	"github.com/bongofriend/torrent-ingest/postprocess"
 	"github.com/bongofriend/torrent-ingest/torrent"
 	"github.com/bongofriend/torrent-ingest/ytdlp"
 	validation "github.com/go-ozzo/ozzo-validation"
@@ -56,10 +59,11 @@ func (y ytdlpDownlinkRequest) Validate() error {
 	)
 }
 
-func registerEndpoints(mux *http.ServeMux, transmissionClient torrent.TransmissionClient, ytdlpDownloadService ytdlp.YtdlpDownloadService) {
+func registerEndpoints(mux *http.ServeMux, transmissionClient torrent.TransmissionClient, ytdlpDownloadService ytdlp.YtdlpDownloadService, jobStore postprocess.JobStore) {
 	mux.HandleFunc("POST /torrent/magnetlink", handleMagnetLink(transmissionClient))
 	mux.HandleFunc("POST /torrent/file", handleTorrentFile(transmissionClient))
 	mux.HandleFunc("POST /youtube/download", handleYoutubeDownload(ytdlpDownloadService))
+	mux.HandleFunc("GET /jobs", handleJobs(jobStore))
 	mux.HandleFunc("GET /health", handleHealth)
 }
 
@@ -82,7 +86,7 @@ func handleMagnetLink(transmissionClient torrent.TransmissionClient) http.Handle
 			MagnetLink: requestBody.MagnetLink,
 		}); err != nil {
 			log.Println(err)
-			internalServerError(w)
+			submissionError(w, err)
 		}
 	}
 }
@@ -127,7 +131,7 @@ func handleTorrentFile(transmissionClient torrent.TransmissionClient) http.Handl
 			TorrentFileContent: request.TorrentFileContent,
 		}); err != nil {
 			log.Println(err)
-			internalServerError(w)
+			submissionError(w, err)
 			return
 		}
 	}
@@ -152,14 +156,32 @@ func handleYoutubeDownload(ytdlpDownloadService ytdlp.YtdlpDownloadService) http
 			UrlType:  requestBody.YoutubeUrlType,
 			Category: requestBody.Category,
 		}
-		if err := ytdlpDownloadService.QueueDownload(r.Context(), downloadRequest); err != nil {
+		if _, err := ytdlpDownloadService.QueueDownload(r.Context(), downloadRequest); err != nil {
 			log.Println(err)
-			internalServerError(w)
+			submissionError(w, err)
 			return
 		}
 	}
 }
 
+func handleJobs(jobStore postprocess.JobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(jobStore.List()); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
+
+// submissionError rejects new downloads with 507 while disk space is low
+func submissionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, diskspace.ErrLowSpace) {
+		http.Error(w, insufficientStorageMessage, http.StatusInsufficientStorage)
+		return
+	}
+	internalServerError(w)
+}
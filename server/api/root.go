@@ -6,12 +6,21 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/diskspace"
+	"github.com/bongofriend/torrent-ingest/events"
+	"github.com/bongofriend/torrent-ingest/mediaserver"
+	"github.com/bongofriend/torrent-ingest/notify"
+	"github.com/bongofriend/torrent-ingest/postprocess"
+	"github.com/bongofriend/torrent-ingest/telegram"
 	"github.com/bongofriend/torrent-ingest/torrent"
+	"github.com/bongofriend/torrent-ingest/watch"
+	"github.com/bongofriend/torrent-ingest/webhook"
 	"github.com/bongofriend/torrent-ingest/ytdlp"
 )
 
@@ -19,18 +28,54 @@ func Run(ctx context.Context, appConfig config.AppConfig) {
 
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGABRT, syscall.SIGINT)
-	transmissionClient, err := torrent.NewTransmissionClient(appConfig.Torrent.Transmission)
+	eventBus := events.NewBus()
+	for _, l := range webhook.NewListeners(appConfig.Webhooks) {
+		eventBus.Subscribe(l)
+	}
+	notifiers, err := notify.NewListeners(appConfig.Notifications)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, l := range notifiers {
+		eventBus.Subscribe(l)
+	}
+	for _, l := range mediaserver.NewListeners(appConfig.Paths, appConfig.Categories) {
+		eventBus.Subscribe(l)
+	}
+
+	guard := diskspace.NewGuard(appConfig.Paths)
+	transmissionClient, err := torrent.NewTransmissionClient(appConfig.Torrent.Transmission, eventBus, guard)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	printConfig(appConfig)
 
-	torrentProcessor := torrent.NewFinishedTorrentProcessor(transmissionClient, appConfig.Paths)
+	postprocess.CleanStagingDirs(appConfig.Paths)
+	jobStore := postprocess.NewJobStore()
+	importer := postprocess.NewImporter(appConfig.Paths, guard, appConfig.Categories, jobStore, eventBus)
+	torrentProcessor := torrent.NewFinishedTorrentProcessor(transmissionClient, appConfig.Paths, appConfig.Categories, appConfig.Torrent.Cleanup, importer, guard)
+	orphanScanner := torrent.NewOrphanScanner(transmissionClient, appConfig.Paths, appConfig.Torrent.Cleanup.Orphans)
 	appContext, cancel := context.WithCancel(context.Background())
 	wg := &sync.WaitGroup{}
 
-	ytdlpService := ytdlp.NewYtlDlpService(appConfig.Paths)
+	ytdlpService := ytdlp.NewYtlDlpService(importer, eventBus, guard, appConfig.Categories)
+
+	if len(appConfig.Telegram.Token) > 0 {
+		telegramBot := telegram.NewBot(appConfig.Telegram, transmissionClient, ytdlpService)
+		eventBus.Subscribe(telegramBot)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			telegramBot.Start(appContext)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		eventBus.Start(appContext)
+	}()
 
 	wg.Add(1)
 	go func() {
@@ -38,6 +83,19 @@ func Run(ctx context.Context, appConfig config.AppConfig) {
 		torrentProcessor.Start(appContext, appConfig.Torrent.PollingInterval)
 	}()
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		orphanScanner.Start(appContext)
+	}()
+
+	watcher := watch.NewWatcher(appConfig.Watch, transmissionClient, ytdlpService)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		watcher.Start(appContext)
+	}()
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -47,7 +105,7 @@ func Run(ctx context.Context, appConfig config.AppConfig) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		startServer(appContext, appConfig, transmissionClient, ytdlpService)
+		startServer(appContext, appConfig, transmissionClient, ytdlpService, jobStore)
 	}()
 
 	sig := <-signalChan
@@ -88,4 +146,16 @@ func printConfig(appConfig config.AppConfig) {
 	if appConfig.Paths.Destinations.Music != "" {
 		log.Printf("   - Music: %s", appConfig.Paths.Destinations.Music)
 	}
+	if len(appConfig.Webhooks.Endpoints) > 0 {
+		log.Printf(" - Webhooks: %d endpoint(s)", len(appConfig.Webhooks.Endpoints))
+	}
+	if n := len(appConfig.Notifications.Ntfy) + len(appConfig.Notifications.Gotify); n > 0 {
+		log.Printf(" - Push notifications: %d provider(s)", n)
+	}
+	for _, e := range appConfig.Notifications.Email {
+		log.Printf(" - Email notifications: %s", strings.Join(e.To, ", "))
+	}
+	if len(appConfig.Telegram.Token) > 0 {
+		log.Printf(" - Telegram bot: %d allowed user(s)", len(appConfig.Telegram.AllowedUsers))
+	}
 }
@@ -9,13 +9,14 @@ import (
 	"time"
 
 	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/postprocess"
 	"github.com/bongofriend/torrent-ingest/torrent"
 	"github.com/bongofriend/torrent-ingest/ytdlp"
 )
 
-func startServer(appContext context.Context, appConfig config.AppConfig, transmissionClient torrent.TransmissionClient, ytdlpDownloadService ytdlp.YtdlpDownloadService) {
+func startServer(appContext context.Context, appConfig config.AppConfig, transmissionClient torrent.TransmissionClient, ytdlpDownloadService ytdlp.YtdlpDownloadService, jobStore postprocess.JobStore) {
 	apiMux := http.NewServeMux()
-	registerEndpoints(apiMux, transmissionClient, ytdlpDownloadService)
+	registerEndpoints(apiMux, transmissionClient, ytdlpDownloadService, jobStore)
 
 	middleware := applyMiddleware(logging(), auth(appConfig.Server))
 	server := &http.Server{
@@ -6,6 +6,7 @@ const (
 	unauthorizedMessage        string = "Unauthorized"
 	internalServerErrorMessage string = "Internal Server Error"
 	badRequestMessage          string = "Bad Request"
+	insufficientStorageMessage string = "Insufficient Storage"
 )
 
 func unauthorized(w http.ResponseWriter) {
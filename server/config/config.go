@@ -1,18 +1,35 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"os/user"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
+	"github.com/bongofriend/torrent-ingest/models"
+	"github.com/bongofriend/torrent-ingest/release"
+
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
 	"github.com/goccy/go-yaml"
 )
 
 type AppConfig struct {
-	Server  ServerConfig  `yaml:"server"`
-	Torrent TorrentConfig `yaml:"torrent"`
-	Paths   PathConfig    `yaml:"paths"`
+	Server        ServerConfig        `yaml:"server"`
+	Torrent       TorrentConfig       `yaml:"torrent"`
+	Paths         PathConfig          `yaml:"paths"`
+	Categories    CategoriesConfig    `yaml:"categories"`
+	Webhooks      WebhooksConfig      `yaml:"webhooks"`
+	Notifications NotificationsConfig `yaml:"notifications"`
+	Telegram      TelegramConfig      `yaml:"telegram"`
+	Watch         WatchConfig         `yaml:"watch"`
 }
 
 func (a AppConfig) Validate() error {
@@ -20,6 +37,11 @@ func (a AppConfig) Validate() error {
 		validation.Field(&a.Server),
 		validation.Field(&a.Torrent),
 		validation.Field(&a.Paths),
+		validation.Field(&a.Categories),
+		validation.Field(&a.Webhooks),
+		validation.Field(&a.Notifications),
+		validation.Field(&a.Telegram),
+		validation.Field(&a.Watch),
 	)
 }
 
@@ -40,27 +62,114 @@ func (s ServerConfig) Validate() error {
 type TorrentConfig struct {
 	PollingInterval time.Duration      `yaml:"polling_interval"`
 	Transmission    TransmissionConfig `yaml:"transmission"`
+	Cleanup         CleanupConfig      `yaml:"cleanup"`
 }
 
 func (t TorrentConfig) Validate() error {
 	return validation.ValidateStruct(&t,
 		validation.Field(&t.PollingInterval, validation.Required),
 		validation.Field(&t.Transmission),
+		validation.Field(&t.Cleanup),
+	)
+}
+
+type CleanupPolicy string
+
+const (
+	// KeepData removes imported torrents from Transmission but keeps their files
+	KeepData CleanupPolicy = "keep"
+	// DeleteAfterImport removes imported torrents together with their files
+	DeleteAfterImport CleanupPolicy = "after_import"
+	// DeleteAfterSeeding keeps imported torrents seeding and removes them with their files once they reached their seed limits
+	DeleteAfterSeeding CleanupPolicy = "after_seeding"
+)
+
+// CleanupConfig configures what happens to the downloaded files of torrents
+type CleanupConfig struct {
+	// Policy decides when the files of imported torrents are deleted, defaults to keep
+	Policy CleanupPolicy `yaml:"policy"`
+	// Orphans can only be deleted with a policy deleting the files of imported torrents. With keep the files
+	// are left in the download folder after the torrent is removed, so they are orphans the user chose to keep.
+	Orphans OrphanConfig `yaml:"orphans"`
+}
+
+func (c CleanupConfig) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Policy, validation.In(KeepData, DeleteAfterImport, DeleteAfterSeeding)),
+		validation.Field(&c.Orphans, validation.By(func(interface{}) error {
+			if c.Orphans.Delete && c.Policy != DeleteAfterImport && c.Policy != DeleteAfterSeeding {
+				return fmt.Errorf("delete requires the %s or %s policy as the kept files of imported torrents would be deleted", DeleteAfterImport, DeleteAfterSeeding)
+			}
+			return nil
+		})),
+	)
+}
+
+// OrphanConfig configures the scan for files in the download folder which do not belong to any torrent of Transmission
+type OrphanConfig struct {
+	// Interval of the scan, it is disabled if not set
+	Interval time.Duration `yaml:"interval"`
+	// MinAge skips files which have been modified recently, defaults to one day
+	MinAge time.Duration `yaml:"min_age"`
+	// Delete removes the orphans, otherwise they are only reported as a dry run.
+	// It requires a cleanup policy which deletes the files of imported torrents.
+	Delete bool `yaml:"delete"`
+}
+
+func (o OrphanConfig) Validate() error {
+	return validation.ValidateStruct(&o,
+		validation.Field(&o.Interval, validation.Min(time.Duration(0))),
+		validation.Field(&o.MinAge, validation.Min(time.Duration(0))),
 	)
 }
 
 type PathConfig struct {
 	DownloadBasePath string             `yaml:"download_base_path"`
 	Destinations     DestionationConfig `yaml:"destinations"`
+	// MinFreeSpace is kept free on the destinations, imports wait until there is enough space left for their files
+	MinFreeSpace ByteSize `yaml:"min_free_space"`
+	// MinFreeDownloadSpace is kept free in the download folder, new downloads are rejected below it
+	MinFreeDownloadSpace ByteSize `yaml:"min_free_download_space"`
 }
 
 func (p PathConfig) Validate() error {
 	return validation.ValidateStruct(&p,
 		validation.Field(&p.DownloadBasePath, validation.NilOrNotEmpty),
 		validation.Field(&p.Destinations),
+		validation.Field(&p.MinFreeSpace, validation.Min(ByteSize(0))),
+		validation.Field(&p.MinFreeDownloadSpace, validation.Min(ByteSize(0))),
 	)
 }
 
+// AllDestinations returns the configured destination paths of all categories
+func (p PathConfig) AllDestinations() []string {
+	destinations := []string{}
+	for _, d := range []string{p.Destinations.Audiobooks, p.Destinations.Anime, p.Destinations.Series, p.Destinations.Movie, p.Destinations.Music} {
+		if len(d) > 0 && !slices.Contains(destinations, d) {
+			destinations = append(destinations, d)
+		}
+	}
+	return destinations
+}
+
+// DestinationFor returns the destination path of a category, which is empty if the category has no destination configured
+func (p PathConfig) DestinationFor(category models.MediaCategory) string {
+	switch category {
+	case models.Audiobook:
+		return p.Destinations.Audiobooks
+	case models.Anime:
+		return p.Destinations.Anime
+	case models.Series:
+		return p.Destinations.Series
+	case models.Movies:
+		return p.Destinations.Movie
+	case models.Music:
+		return p.Destinations.Music
+	default:
+		return ""
+	}
+}
+
 type DestionationConfig struct {
 	Audiobooks string `yaml:"audiobooks"`
 	Anime      string `yaml:"anime"`
@@ -69,6 +178,590 @@ type DestionationConfig struct {
 	Music      string `yaml:"music"`
 }
 
+type CategoriesConfig map[models.MediaCategory]CategoryConfig
+
+func (c CategoriesConfig) Validate() error {
+	for category := range c {
+		if err := category.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", category, err)
+		}
+	}
+	return validation.Validate(map[models.MediaCategory]CategoryConfig(c))
+}
+
+// For returns the configuration of a category, falling back to the zero value if the category is not configured
+func (c CategoriesConfig) For(category models.MediaCategory) CategoryConfig {
+	return c[category]
+}
+
+type CategoryConfig struct {
+	// Stages overrides the order of the post-processing stages, stages not listed are not run
+	Stages    []models.StageName `yaml:"stages"`
+	Filter    FilterConfig       `yaml:"filter"`
+	Extract   ExtractConfig      `yaml:"extract"`
+	Verify    VerifyConfig       `yaml:"verify"`
+	Tag       TagConfig          `yaml:"tag"`
+	Audiobook AudiobookConfig    `yaml:"audiobook"`
+	Transcode TranscodeConfig    `yaml:"transcode"`
+	Rename    RenameConfig       `yaml:"rename"`
+	Subtitles SubtitlesConfig    `yaml:"subtitles"`
+	Metadata  MetadataConfig     `yaml:"metadata"`
+	// Conflict decides what happens to files which already exist in the destination, defaults to overwrite
+	Conflict ConflictPolicy `yaml:"conflict"`
+	// Permissions are applied to the imported files and the folders created for them
+	Permissions PermissionsConfig `yaml:"permissions"`
+	Hooks       HooksConfig       `yaml:"hooks"`
+	// MediaServers are asked to rescan their library once a job of the category has been imported
+	MediaServers []MediaServerConfig `yaml:"media_servers"`
+}
+
+func (c CategoryConfig) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Stages, validation.By(includesTransferStage)),
+		validation.Field(&c.Filter),
+		validation.Field(&c.Extract),
+		validation.Field(&c.Verify),
+		validation.Field(&c.Tag, validation.By(func(value interface{}) error {
+			// Tracks of the music layout are sorted by their tags only
+			if c.Rename.Layout == MusicLayout && (!c.Tag.Enabled || (len(c.Stages) > 0 && !slices.Contains(c.Stages, models.TagStage))) {
+				return fmt.Errorf("must be enabled for the %s layout", MusicLayout)
+			}
+			return nil
+		})),
+		validation.Field(&c.Audiobook),
+		validation.Field(&c.Transcode),
+		validation.Field(&c.Rename),
+		validation.Field(&c.Subtitles),
+		validation.Field(&c.Conflict, validation.In(SkipConflict, OverwriteConflict, RenameConflict, KeepLargerConflict, FailConflict)),
+		validation.Field(&c.Permissions),
+		validation.Field(&c.Hooks),
+		validation.Field(&c.MediaServers),
+	)
+}
+
+func includesTransferStage(value interface{}) error {
+	stages, _ := value.([]models.StageName)
+	if len(stages) > 0 && !slices.Contains(stages, models.TransferStage) {
+		return fmt.Errorf("must include %s", models.TransferStage)
+	}
+	return nil
+}
+
+type FilterConfig struct {
+	AllowExtensions []string `yaml:"allow_extensions"`
+	DenyExtensions  []string `yaml:"deny_extensions"`
+	AllowPatterns   []string `yaml:"allow_patterns"`
+	DenyPatterns    []string `yaml:"deny_patterns"`
+	MinSize         ByteSize `yaml:"min_size"`
+	SkipSamples     bool     `yaml:"skip_samples"`
+}
+
+func (f FilterConfig) Validate() error {
+	return validation.ValidateStruct(&f,
+		validation.Field(&f.AllowExtensions, validation.Each(validation.Required)),
+		validation.Field(&f.DenyExtensions, validation.Each(validation.Required)),
+		validation.Field(&f.AllowPatterns, validation.Each(validation.Required, validation.By(validGlobPattern))),
+		validation.Field(&f.DenyPatterns, validation.Each(validation.Required, validation.By(validGlobPattern))),
+		validation.Field(&f.MinSize, validation.Min(ByteSize(0))),
+	)
+}
+
+func validGlobPattern(value interface{}) error {
+	pattern, _ := value.(string)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return errors.New("must be a valid glob pattern")
+	}
+	if strings.Contains(pattern, "\\") {
+		return errors.New("must use forward slashes")
+	}
+	return nil
+}
+
+type ExtractConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// SkipArchives leaves the archive volumes out of the import once they have been extracted
+	SkipArchives bool `yaml:"skip_archives"`
+	// Command is an external tool used for all archives instead of the built-in extraction, e.g. [unrar, x, -o+, "{archive}", "{dest}/"]
+	Command []string `yaml:"command"`
+}
+
+func (e ExtractConfig) Validate() error {
+	return validation.ValidateStruct(&e,
+		validation.Field(&e.Command, validation.Each(validation.Required)),
+	)
+}
+
+type ConflictPolicy string
+
+const (
+	// SkipConflict keeps the existing file and leaves the new one out of the import
+	SkipConflict ConflictPolicy = "skip"
+	// OverwriteConflict replaces the existing file
+	OverwriteConflict ConflictPolicy = "overwrite"
+	// RenameConflict imports the new file with a suffix like "Movie (2).mkv"
+	RenameConflict ConflictPolicy = "rename"
+	// KeepLargerConflict keeps whichever file is larger, usually the one of higher quality
+	KeepLargerConflict ConflictPolicy = "keep_larger"
+	// FailConflict fails the job without importing any of its files
+	FailConflict ConflictPolicy = "fail"
+)
+
+// PermissionsConfig configures the ownership and modes of imported files, settings left empty keep the defaults of the process
+type PermissionsConfig struct {
+	// Owner is a user name or numeric uid
+	Owner string `yaml:"owner"`
+	// Group is a group name or numeric gid
+	Group    string   `yaml:"group"`
+	FileMode FileMode `yaml:"file_mode"`
+	DirMode  FileMode `yaml:"dir_mode"`
+}
+
+func (p PermissionsConfig) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Owner, validation.By(func(value interface{}) error {
+			_, err := p.Uid()
+			return err
+		})),
+		validation.Field(&p.Group, validation.By(func(value interface{}) error {
+			_, err := p.Gid()
+			return err
+		})),
+	)
+}
+
+// Uid resolves the owner to its uid, -1 if no owner is configured
+func (p PermissionsConfig) Uid() (int, error) {
+	if len(p.Owner) == 0 {
+		return -1, nil
+	}
+	if uid, err := strconv.Atoi(p.Owner); err == nil {
+		return uid, nil
+	}
+	u, err := user.Lookup(p.Owner)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// Gid resolves the group to its gid, -1 if no group is configured
+func (p PermissionsConfig) Gid() (int, error) {
+	if len(p.Group) == 0 {
+		return -1, nil
+	}
+	if gid, err := strconv.Atoi(p.Group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(p.Group)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// TagConfig configures the tagging of audio files
+type TagConfig struct {
+	// Enabled reads the tags of audio files, completed by the metadata of yt-dlp and the file names
+	Enabled bool `yaml:"enabled"`
+	// Write adds the tags which are missing in the files and replaces the ones which have been normalized
+	Write bool `yaml:"write"`
+}
+
+// AudiobookConfig configures the assembly of books split into many audio files into a single M4B file
+type AudiobookConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Bitrate of the AAC encoded book, defaults to 64k
+	Bitrate string `yaml:"bitrate"`
+}
+
+func (a AudiobookConfig) Validate() error {
+	return validation.ValidateStruct(&a,
+		validation.Field(&a.Bitrate, validation.Match(regexp.MustCompile(`^\d+k$`))),
+	)
+}
+
+// SubtitlesConfig configures the handling of external subtitles of videos
+type SubtitlesConfig struct {
+	// Enabled renames subtitles after their video with a language suffix like "Movie.en.srt"
+	Enabled bool `yaml:"enabled"`
+	// Embed adds text based subtitles to mkv and mp4 videos instead of importing them as separate files
+	Embed bool `yaml:"embed"`
+	// Download fetches the subtitles of yt-dlp downloads in the preferred languages
+	Download bool `yaml:"download"`
+	// AutoCaptions includes subtitles generated by the platform in yt-dlp downloads
+	AutoCaptions bool `yaml:"auto_captions"`
+	// Languages are the preferred languages as ISO 639-1 codes like "en", defaults to English
+	Languages []string `yaml:"languages"`
+}
+
+func (s SubtitlesConfig) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Languages, validation.Each(validation.Required, validation.Match(regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z]+)?$`)))),
+	)
+}
+
+type ConversionMode string
+
+const (
+	// RemuxMode copies the streams into another container without encoding them again
+	RemuxMode ConversionMode = "remux"
+	// TranscodeMode encodes videos as H.264 with AAC audio
+	TranscodeMode ConversionMode = "transcode"
+)
+
+// TranscodeConfig configures the conversion of videos with ffmpeg
+type TranscodeConfig struct {
+	Mode ConversionMode `yaml:"mode"`
+	// Container is the format of the converted videos, mp4 or mkv, defaults to mp4
+	Container string `yaml:"container"`
+	// Extensions and VideoCodecs select the videos to convert, e.g. [avi] or [hevc].
+	// Without them every video is converted which is not in the container or, when transcoding, not H.264.
+	Extensions  []string `yaml:"extensions"`
+	VideoCodecs []string `yaml:"video_codecs"`
+	// Crf and Preset configure the quality of libx264, default to 23 and medium
+	Crf    int    `yaml:"crf"`
+	Preset string `yaml:"preset"`
+	// KeepOriginal imports the original video next to the converted one
+	KeepOriginal bool `yaml:"keep_original"`
+}
+
+func (t TranscodeConfig) Validate() error {
+	return validation.ValidateStruct(&t,
+		validation.Field(&t.Mode, validation.In(RemuxMode, TranscodeMode)),
+		validation.Field(&t.Container, validation.In("mp4", "mkv")),
+		validation.Field(&t.Extensions, validation.Each(validation.Required)),
+		validation.Field(&t.VideoCodecs, validation.Each(validation.Required)),
+		validation.Field(&t.Crf, validation.Min(0), validation.Max(51)),
+	)
+}
+
+// MetadataConfig configures the sidecar files generated from the metadata of yt-dlp downloads
+type MetadataConfig struct {
+	// Nfo writes Kodi compatible .nfo files read by Jellyfin, Emby and Kodi
+	Nfo bool `yaml:"nfo"`
+	// Artwork imports the thumbnail as poster.jpg and fanart.jpg
+	Artwork bool `yaml:"artwork"`
+}
+
+type HashAlgorithm string
+
+const (
+	XxhashAlgorithm HashAlgorithm = "xxhash"
+	Sha256Algorithm HashAlgorithm = "sha256"
+)
+
+// VerifyConfig configures the checks of the downloaded files before they are imported
+type VerifyConfig struct {
+	// Crc32 compares files against the checksum in their name like "[Group] Title - 07 [ABCD1234].mkv"
+	Crc32 bool `yaml:"crc32"`
+	// Hash compares the imported copies with their source by checksum, xxhash or sha256. Sizes are always compared.
+	Hash HashAlgorithm `yaml:"hash"`
+	// Pieces has Transmission recheck the pieces of a torrent against its info dict before it is imported
+	Pieces bool `yaml:"pieces"`
+}
+
+func (v VerifyConfig) Validate() error {
+	return validation.ValidateStruct(&v,
+		validation.Field(&v.Hash, validation.In(XxhashAlgorithm, Sha256Algorithm)),
+	)
+}
+
+type RenameLayout string
+
+const (
+	// AudiobookshelfLayout sorts books into Author/Series/Index - Title folders
+	AudiobookshelfLayout RenameLayout = "audiobookshelf"
+	// AnimeLayout files episodes into a folder per show
+	AnimeLayout RenameLayout = "anime"
+	// MusicLayout sorts tracks into AlbumArtist/Album (Year)/NN - Title folders, requires the tag stage
+	MusicLayout RenameLayout = "music"
+)
+
+// RenameConfig configures how imported files are organized below the destination of a category
+type RenameConfig struct {
+	Layout RenameLayout `yaml:"layout"`
+	// Template renders the path of every file from its release name, e.g. "{Title} ({Year})/{Title} ({Year}) - {Resolution}.{ext}"
+	Template string `yaml:"template"`
+}
+
+func (r RenameConfig) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Layout, validation.In(AudiobookshelfLayout, AnimeLayout, MusicLayout)),
+		validation.Field(&r.Template, validation.By(func(value interface{}) error {
+			if len(r.Template) == 0 {
+				return nil
+			}
+			if len(r.Layout) > 0 {
+				return errors.New("must not be combined with a layout")
+			}
+			_, err := release.ParseTemplate(r.Template)
+			return err
+		})),
+	)
+}
+
+// HooksConfig configures external commands which are run once a job has been imported or failed
+type HooksConfig struct {
+	OnComplete []string      `yaml:"on_complete"`
+	OnFailure  []string      `yaml:"on_failure"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+func (h HooksConfig) Validate() error {
+	return validation.ValidateStruct(&h,
+		validation.Field(&h.OnComplete, validation.Each(validation.Required)),
+		validation.Field(&h.OnFailure, validation.Each(validation.Required)),
+		validation.Field(&h.Timeout, validation.Min(time.Duration(0))),
+	)
+}
+
+type WebhooksConfig struct {
+	Endpoints []WebhookEndpointConfig `yaml:"endpoints"`
+	// MaxAttempts is the number of deliveries tried per event before it is written to the dead letter file
+	MaxAttempts int `yaml:"max_attempts"`
+	// DeadLetterFile collects events which could not be delivered as JSON lines. Failed deliveries are only logged if unset.
+	DeadLetterFile string `yaml:"dead_letter_file"`
+}
+
+func (w WebhooksConfig) Validate() error {
+	return validation.ValidateStruct(&w,
+		validation.Field(&w.Endpoints),
+		validation.Field(&w.MaxAttempts, validation.Min(0)),
+	)
+}
+
+type WebhookEndpointConfig struct {
+	Url string `yaml:"url"`
+	// Secret is the key of the HMAC-SHA256 signature sent with every delivery
+	Secret string `yaml:"secret"`
+	// Events limits the delivered event types, all events are delivered if empty
+	Events  []models.EventType `yaml:"events"`
+	Timeout time.Duration      `yaml:"timeout"`
+}
+
+func (w WebhookEndpointConfig) Validate() error {
+	return validation.ValidateStruct(&w,
+		validation.Field(&w.Url, validation.Required, is.URL),
+		validation.Field(&w.Secret, validation.Required),
+		validation.Field(&w.Events),
+		validation.Field(&w.Timeout, validation.Min(time.Duration(0))),
+	)
+}
+
+type NotificationsConfig struct {
+	// Templates override the default title and message per event type
+	Templates map[models.EventType]NotificationTemplateConfig `yaml:"templates"`
+	Ntfy      []NtfyConfig                                    `yaml:"ntfy"`
+	Gotify    []GotifyConfig                                  `yaml:"gotify"`
+	Email     []EmailConfig                                   `yaml:"email"`
+}
+
+func (n NotificationsConfig) Validate() error {
+	for eventType, t := range n.Templates {
+		if err := eventType.Validate(); err != nil {
+			return fmt.Errorf("templates: %s: %w", eventType, err)
+		}
+		if err := t.Validate(); err != nil {
+			return fmt.Errorf("templates: %s: %w", eventType, err)
+		}
+	}
+	return validation.ValidateStruct(&n,
+		validation.Field(&n.Ntfy),
+		validation.Field(&n.Gotify),
+		validation.Field(&n.Email),
+	)
+}
+
+// NotificationTemplateConfig contains Go templates which are rendered with the event
+type NotificationTemplateConfig struct {
+	Title   string `yaml:"title"`
+	Message string `yaml:"message"`
+}
+
+func (n NotificationTemplateConfig) Validate() error {
+	return validation.ValidateStruct(&n,
+		validation.Field(&n.Title, validation.By(validTemplate)),
+		validation.Field(&n.Message, validation.By(validTemplate)),
+	)
+}
+
+func validTemplate(value interface{}) error {
+	text, _ := value.(string)
+	_, err := template.New("").Parse(text)
+	return err
+}
+
+// NotifierConfig contains the settings shared by all push notification providers
+type NotifierConfig struct {
+	// Events limits the notified event types, defaults to imported, failed and low_space
+	Events []models.EventType `yaml:"events"`
+	// Priorities sets the provider specific priority per event type
+	Priorities map[models.EventType]int `yaml:"priorities"`
+}
+
+func (n NotifierConfig) Validate() error {
+	for eventType := range n.Priorities {
+		if err := eventType.Validate(); err != nil {
+			return fmt.Errorf("priorities: %s: %w", eventType, err)
+		}
+	}
+	return validation.ValidateStruct(&n,
+		validation.Field(&n.Events),
+	)
+}
+
+type NtfyConfig struct {
+	NotifierConfig `yaml:",inline"`
+	Url            string `yaml:"url"`
+	Topic          string `yaml:"topic"`
+	Token          string `yaml:"token"`
+}
+
+func (n NtfyConfig) Validate() error {
+	return validation.ValidateStruct(&n,
+		validation.Field(&n.NotifierConfig),
+		validation.Field(&n.Url, validation.Required, is.URL),
+		validation.Field(&n.Topic, validation.Required),
+	)
+}
+
+type GotifyConfig struct {
+	NotifierConfig `yaml:",inline"`
+	Url            string `yaml:"url"`
+	Token          string `yaml:"token"`
+}
+
+func (g GotifyConfig) Validate() error {
+	return validation.ValidateStruct(&g,
+		validation.Field(&g.NotifierConfig),
+		validation.Field(&g.Url, validation.Required, is.URL),
+		validation.Field(&g.Token, validation.Required),
+	)
+}
+
+type SmtpSecurity string
+
+const (
+	SmtpStartTls SmtpSecurity = "starttls"
+	SmtpTls      SmtpSecurity = "tls"
+	SmtpNone     SmtpSecurity = "none"
+)
+
+type EmailConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// Security defaults to starttls
+	Security SmtpSecurity `yaml:"security"`
+	Username string       `yaml:"username"`
+	Password string       `yaml:"password"`
+	From     string       `yaml:"from"`
+	To       []string     `yaml:"to"`
+	// Events are mailed as soon as they occur, no mails are sent per event if empty
+	Events []models.EventType `yaml:"events"`
+	Digest EmailDigestConfig  `yaml:"digest"`
+}
+
+func (e EmailConfig) Validate() error {
+	return validation.ValidateStruct(&e,
+		validation.Field(&e.Host, validation.Required),
+		validation.Field(&e.Port, validation.Required, validation.Min(1), validation.Max(65535)),
+		validation.Field(&e.Security, validation.In(SmtpStartTls, SmtpTls, SmtpNone)),
+		validation.Field(&e.From, validation.Required, is.Email),
+		validation.Field(&e.To, validation.Required, validation.Each(is.Email)),
+		validation.Field(&e.Events),
+		validation.Field(&e.Digest),
+	)
+}
+
+// EmailDigestConfig configures a daily summary of all imported and failed jobs
+type EmailDigestConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Time of day the digest is sent at as HH:MM in local time, defaults to 08:00
+	Time string `yaml:"time"`
+}
+
+func (e EmailDigestConfig) Validate() error {
+	return validation.ValidateStruct(&e,
+		validation.Field(&e.Time, validation.Date("15:04")),
+	)
+}
+
+// TelegramConfig configures the Telegram bot, which is disabled if no token is set
+type TelegramConfig struct {
+	Token string `yaml:"token"`
+	// ApiUrl is the base URL of the Bot API, defaults to https://api.telegram.org
+	ApiUrl string `yaml:"api_url"`
+	// AllowedUsers contains the ids of the Telegram users which may use the bot
+	AllowedUsers []int64 `yaml:"allowed_users"`
+}
+
+func (t TelegramConfig) Validate() error {
+	return validation.ValidateStruct(&t,
+		validation.Field(&t.ApiUrl, is.URL),
+		validation.Field(&t.AllowedUsers, validation.By(func(interface{}) error {
+			if len(t.Token) > 0 && len(t.AllowedUsers) == 0 {
+				return errors.New("cannot be blank if a token is set")
+			}
+			return nil
+		})),
+	)
+}
+
+// WatchConfig configures the watch folders, a folder per category like "movies" below Path is watched for .torrent files
+// and text files with magnet links or URLs. Watching is disabled if no path is set.
+type WatchConfig struct {
+	Path string `yaml:"path"`
+	// PollingInterval is the interval the folders are scanned in, besides inotify events, defaults to 30s
+	PollingInterval time.Duration `yaml:"polling_interval"`
+	// Polling only scans the folders periodically, for file systems without inotify support like network shares
+	Polling bool `yaml:"polling"`
+}
+
+func (w WatchConfig) Validate() error {
+	return validation.ValidateStruct(&w,
+		validation.Field(&w.PollingInterval, validation.Min(time.Duration(0))),
+	)
+}
+
+type MediaServerType string
+
+const (
+	Jellyfin MediaServerType = "jellyfin"
+	Emby     MediaServerType = "emby"
+	Plex     MediaServerType = "plex"
+	// Audiobookshelf scans the folders of imported books
+	Audiobookshelf MediaServerType = "audiobookshelf"
+)
+
+type MediaServerConfig struct {
+	Type  MediaServerType `yaml:"type"`
+	Url   string          `yaml:"url"`
+	Token string          `yaml:"token"`
+	// Path is the category destination as seen by the media server, defaults to the destination itself
+	Path string `yaml:"path"`
+	// Section is the id of the Plex library section, required for path scoped refreshes in Plex
+	Section string `yaml:"section"`
+	// Library is the id of the Audiobookshelf library the category is imported into
+	Library string `yaml:"library"`
+	// Debounce collects imports for this long before triggering a single refresh, defaults to 30s
+	Debounce time.Duration `yaml:"debounce"`
+}
+
+func (m MediaServerConfig) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Type, validation.Required, validation.In(Jellyfin, Emby, Plex, Audiobookshelf)),
+		validation.Field(&m.Url, validation.Required, is.URL),
+		validation.Field(&m.Token, validation.Required),
+		validation.Field(&m.Library, validation.By(func(value interface{}) error {
+			if m.Type == Audiobookshelf {
+				return validation.Validate(value, validation.Required)
+			}
+			return nil
+		})),
+		validation.Field(&m.Debounce, validation.Min(time.Duration(0))),
+	)
+}
+
 type TransmissionConfig struct {
 	Url      string `yaml:"url"`
 	Username string `yaml:"username"`
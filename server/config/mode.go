@@ -0,0 +1,30 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FileMode is a permission mode written in YAML as an octal number like 0644
+type FileMode os.FileMode
+
+// UnmarshalYAML reads the mode from the raw YAML value as it would otherwise be decoded as a decimal or YAML 1.1 octal number
+func (f *FileMode) UnmarshalYAML(raw []byte) error {
+	return f.UnmarshalText([]byte(strings.Trim(strings.TrimSpace(string(raw)), `"'`)))
+}
+
+func (f *FileMode) UnmarshalText(text []byte) error {
+	value := strings.TrimPrefix(strings.TrimSpace(string(text)), "0o")
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o7777 {
+		return fmt.Errorf("invalid file mode %q", string(text))
+	}
+	*f = FileMode(mode)
+	return nil
+}
+
+func (f FileMode) String() string {
+	return fmt.Sprintf("%04o", uint32(f))
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a size in bytes which can be written in YAML either as a plain number or with a unit like "50MB" or "1.5GiB"
+type ByteSize int64
+
+const (
+	kilobyte ByteSize = 1000
+	megabyte          = 1000 * kilobyte
+	gigabyte          = 1000 * megabyte
+	terabyte          = 1000 * gigabyte
+
+	kibibyte ByteSize = 1024
+	mebibyte          = 1024 * kibibyte
+	gibibyte          = 1024 * mebibyte
+	tebibyte          = 1024 * gibibyte
+)
+
+var byteSizeUnits = map[string]ByteSize{
+	"":    1,
+	"B":   1,
+	"KB":  kilobyte,
+	"MB":  megabyte,
+	"GB":  gigabyte,
+	"TB":  terabyte,
+	"KIB": kibibyte,
+	"MIB": mebibyte,
+	"GIB": gibibyte,
+	"TIB": tebibyte,
+}
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	value := strings.ToUpper(strings.TrimSpace(string(text)))
+	idx := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	number, unit := value, ""
+	if idx >= 0 {
+		number, unit = value[:idx], strings.TrimSpace(value[idx:])
+	}
+	factor, ok := byteSizeUnits[unit]
+	if !ok {
+		return fmt.Errorf("unknown size unit %q", unit)
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q: %w", string(text), err)
+	}
+	*b = ByteSize(n * float64(factor))
+	return nil
+}
+
+func (b ByteSize) String() string {
+	units := []struct {
+		name string
+		size ByteSize
+	}{{"TiB", tebibyte}, {"GiB", gibibyte}, {"MiB", mebibyte}, {"KiB", kibibyte}}
+	for _, u := range units {
+		if b >= u.size {
+			return fmt.Sprintf("%.1f%s", float64(b)/float64(u.size), u.name)
+		}
+	}
+	return fmt.Sprintf("%dB", int64(b))
+}
@@ -0,0 +1,136 @@
+package diskspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/config"
+)
+
+const (
+	checkInterval time.Duration = time.Minute
+)
+
+var (
+	ErrLowSpace error = errors.New("low disk space")
+)
+
+// Guard keeps the configured minimum of space free on the download folder and the destinations
+type Guard interface {
+	// Check returns ErrLowSpace if a download folder or destination has less space free than its minimum
+	Check() error
+	// Wait blocks until Check succeeds
+	Wait(ctx context.Context) error
+	// WaitFor blocks until the destination has room for size bytes on top of its minimum. onLow is called once if it has to wait.
+	WaitFor(ctx context.Context, destination string, size int64, onLow func(err error)) error
+}
+
+type guard struct {
+	pathConfig config.PathConfig
+	mu         *sync.Mutex
+	low        bool
+}
+
+func NewGuard(pathConfig config.PathConfig) Guard {
+	return &guard{
+		pathConfig: pathConfig,
+		mu:         &sync.Mutex{},
+	}
+}
+
+// Check implements Guard.
+func (g *guard) Check() error {
+	errs := []error{}
+	if len(g.pathConfig.DownloadBasePath) > 0 {
+		errs = append(errs, checkFree(g.pathConfig.DownloadBasePath, 0, int64(g.pathConfig.MinFreeDownloadSpace)))
+	}
+	for _, d := range g.pathConfig.AllDestinations() {
+		errs = append(errs, checkFree(d, 0, int64(g.pathConfig.MinFreeSpace)))
+	}
+	err := errors.Join(errs...)
+
+	// Only changes are logged as Check is called for every poll and submission
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err != nil && !g.low {
+		log.Printf("Pausing imports and rejecting new downloads: %s", err)
+	} else if err == nil && g.low {
+		log.Println("Disk space is available again, resuming imports")
+	}
+	g.low = err != nil
+	return err
+}
+
+// Wait implements Guard.
+func (g *guard) Wait(ctx context.Context) error {
+	for g.Check() != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(checkInterval):
+		}
+	}
+	return nil
+}
+
+// WaitFor implements Guard.
+func (g *guard) WaitFor(ctx context.Context, destination string, size int64, onLow func(err error)) error {
+	notified := false
+	for {
+		err := checkFree(destination, size, int64(g.pathConfig.MinFreeSpace))
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrLowSpace) {
+			return err
+		}
+		if !notified {
+			log.Printf("Waiting for disk space: %s", err)
+			onLow(err)
+			notified = true
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(checkInterval):
+		}
+	}
+}
+
+// checkFree returns ErrLowSpace if path has less than size bytes free in addition to minFree
+func checkFree(path string, size int64, minFree int64) error {
+	free, err := Free(path)
+	if err != nil {
+		return err
+	}
+	if free-size >= minFree {
+		return nil
+	}
+	if size > 0 {
+		return fmt.Errorf("%w: %s has %s free, %s are needed while keeping %s free", ErrLowSpace, path, config.ByteSize(free), config.ByteSize(size), config.ByteSize(minFree))
+	}
+	return fmt.Errorf("%w: %s has %s free, less than the minimum of %s", ErrLowSpace, path, config.ByteSize(free), config.ByteSize(minFree))
+}
+
+// Free returns the bytes available on the filesystem of path. Paths which do not exist yet are checked by their closest existing parent.
+func Free(path string) (int64, error) {
+	stat := syscall.Statfs_t{}
+	for {
+		err := syscall.Statfs(path, &stat)
+		if err == nil {
+			return int64(stat.Bavail) * int64(stat.Bsize), nil
+		}
+		parent := filepath.Dir(path)
+		if !errors.Is(err, fs.ErrNotExist) || parent == path {
+			return 0, fmt.Errorf("reading free space of %s: %w", path, err)
+		}
+		path = parent
+	}
+}
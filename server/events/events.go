@@ -0,0 +1,127 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+const (
+	listenerQueueSize int = 100
+)
+
+// Event is emitted whenever a job reaches a new point of its lifecycle
+type Event struct {
+	Type models.EventType `json:"type"`
+	Time time.Time        `json:"time"`
+	Job  models.Job       `json:"job"`
+}
+
+type Listener interface {
+	Handle(ctx context.Context, event Event)
+}
+
+// Runner is implemented by listeners which need a background routine, e.g. for scheduled work.
+// The bus runs it alongside the listener's queue.
+type Runner interface {
+	Run(ctx context.Context)
+}
+
+// Dropper is implemented by listeners which keep the events dropped because their queue is full, e.g. to deliver them later.
+// Drop is called by the publishing routine and should return quickly.
+type Dropper interface {
+	Drop(event Event)
+}
+
+type Publisher interface {
+	Publish(eventType models.EventType, job *models.Job)
+}
+
+// Bus delivers published events to every subscribed listener.
+// Each listener consumes its own queue so a slow listener does not hold up the others.
+type Bus interface {
+	Publisher
+	Subscribe(listener Listener)
+	Start(ctx context.Context)
+}
+
+type subscription struct {
+	listener Listener
+	queue    chan Event
+}
+
+type bus struct {
+	mu            *sync.RWMutex
+	subscriptions []subscription
+}
+
+func NewBus() Bus {
+	return &bus{
+		mu: &sync.RWMutex{},
+	}
+}
+
+// Subscribe implements Bus.
+func (b *bus) Subscribe(listener Listener) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscriptions = append(b.subscriptions, subscription{
+		listener: listener,
+		queue:    make(chan Event, listenerQueueSize),
+	})
+}
+
+// Publish implements Publisher.
+func (b *bus) Publish(eventType models.EventType, job *models.Job) {
+	event := Event{
+		Type: eventType,
+		Time: time.Now(),
+		Job:  job.Clone(),
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, s := range b.subscriptions {
+		select {
+		case s.queue <- event:
+		default:
+			log.Printf("Event queue full, dropping %s event of job %s", event.Type, event.Job.Id)
+			if d, ok := s.listener.(Dropper); ok {
+				d.Drop(event)
+			}
+		}
+	}
+}
+
+// Start implements Bus.
+func (b *bus) Start(ctx context.Context) {
+	b.mu.RLock()
+	subscriptions := b.subscriptions
+	b.mu.RUnlock()
+
+	wg := &sync.WaitGroup{}
+	for _, s := range subscriptions {
+		if r, ok := s.listener.(Runner); ok {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.Run(ctx)
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case e := <-s.queue:
+					s.listener.Handle(ctx, e)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
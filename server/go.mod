@@ -3,17 +3,20 @@ module github.com/bongofriend/torrent-ingest
 go 1.24.2
 
 require (
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/fsnotify/fsnotify v1.10.1
 	github.com/go-ozzo/ozzo-validation v3.6.0+incompatible
 	github.com/goccy/go-yaml v1.17.1
 	github.com/hekmon/transmissionrpc/v3 v3.0.0
+	github.com/lrstanley/go-ytdlp v1.2.7
+	github.com/nwaples/rardecode/v2 v2.4.1
 	github.com/otiai10/copy v1.14.1
+	github.com/ulikunitz/xz v0.5.15
 )
 
 require (
 	github.com/ProtonMail/go-crypto v1.3.0 // indirect
 	github.com/cloudflare/circl v1.6.2 // indirect
-	github.com/lrstanley/go-ytdlp v1.2.7 // indirect
-	github.com/ulikunitz/xz v0.5.15 // indirect
 	golang.org/x/crypto v0.46.0 // indirect
 )
 
@@ -0,0 +1,86 @@
+package mediaserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bongofriend/torrent-ingest/config"
+)
+
+var (
+	errNotFound           = errors.New("not found")
+	errWatcherUnavailable = errors.New("watcher endpoint not available")
+)
+
+type watcherUpdateRequest struct {
+	LibraryId string `json:"libraryId"`
+	Path      string `json:"path"`
+	Type      string `json:"type"`
+}
+
+// audiobookshelfRefresher notifies Audiobookshelf about imported files so only the affected books are scanned
+type audiobookshelfRefresher struct {
+	client *http.Client
+	config config.MediaServerConfig
+}
+
+// refresh implements refresher.
+func (a audiobookshelfRefresher) refresh(ctx context.Context, paths []string) error {
+	baseUrl := strings.TrimSuffix(a.config.Url, "/")
+	for i, p := range paths {
+		err := a.notifyAdded(ctx, baseUrl, p)
+		if i == 0 && errors.Is(err, errWatcherUnavailable) {
+			// Older versions without the watcher endpoint only support scanning the whole library
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if i == len(paths)-1 {
+			return nil
+		}
+	}
+	err := a.post(ctx, fmt.Sprintf("%s/api/libraries/%s/scan", baseUrl, url.PathEscape(a.config.Library)), nil)
+	if errors.Is(err, errNotFound) {
+		return fmt.Errorf("library %s not found, check the library ID configured for %s", a.config.Library, a.config.Url)
+	}
+	return err
+}
+
+func (a audiobookshelfRefresher) notifyAdded(ctx context.Context, baseUrl string, path string) error {
+	body, err := json.Marshal(watcherUpdateRequest{LibraryId: a.config.Library, Path: path, Type: "add"})
+	if err != nil {
+		return err
+	}
+	err = a.post(ctx, baseUrl+"/api/watcher/update", body)
+	if errors.Is(err, errNotFound) {
+		return errWatcherUnavailable
+	}
+	return err
+}
+
+func (a audiobookshelfRefresher) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+a.config.Token)
+	rsp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode == http.StatusNotFound {
+		rsp.Body.Close()
+		return errNotFound
+	}
+	return checkResponse(rsp)
+}
@@ -0,0 +1,57 @@
+package mediaserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bongofriend/torrent-ingest/config"
+)
+
+func TestAudiobookshelfRefresh(t *testing.T) {
+	tests := []struct {
+		name    string
+		watcher int
+		scan    int
+		// requested paths, in order
+		want    []string
+		wantErr string
+	}{
+		{"watcher", http.StatusOK, http.StatusOK, []string{"/api/watcher/update", "/api/watcher/update"}, ""},
+		{"without watcher", http.StatusNotFound, http.StatusOK, []string{"/api/watcher/update", "/api/libraries/lib-1/scan"}, ""},
+		{"unknown library", http.StatusNotFound, http.StatusNotFound, []string{"/api/watcher/update", "/api/libraries/lib-1/scan"}, "library lib-1 not found"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requested := []string{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requested = append(requested, r.URL.Path)
+				if r.Header.Get("Authorization") != "Bearer token" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				if strings.HasPrefix(r.URL.Path, "/api/watcher/") {
+					w.WriteHeader(test.watcher)
+					return
+				}
+				w.WriteHeader(test.scan)
+			}))
+			defer server.Close()
+			a := audiobookshelfRefresher{client: server.Client(), config: config.MediaServerConfig{Url: server.URL, Token: "token", Library: "lib-1"}}
+
+			err := a.refresh(context.Background(), []string{"/books/Author/Book/01.mp3", "/books/Author/Book/02.mp3"})
+
+			if len(test.wantErr) == 0 && err != nil {
+				t.Errorf("refresh() failed: %s", err)
+			}
+			if len(test.wantErr) > 0 && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("refresh() error = %v, want %q", err, test.wantErr)
+			}
+			if strings.Join(requested, ",") != strings.Join(test.want, ",") {
+				t.Errorf("requested %q, want %q", requested, test.want)
+			}
+		})
+	}
+}
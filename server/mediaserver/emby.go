@@ -0,0 +1,63 @@
+package mediaserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/bongofriend/torrent-ingest/config"
+)
+
+const (
+	embyTokenHeader string = "X-Emby-Token"
+)
+
+type mediaUpdate struct {
+	Path       string `json:"Path"`
+	UpdateType string `json:"UpdateType"`
+}
+
+type mediaUpdatedRequest struct {
+	Updates []mediaUpdate `json:"Updates"`
+}
+
+// embyRefresher refreshes Emby and Jellyfin libraries, which share the same library API
+type embyRefresher struct {
+	client *http.Client
+	config config.MediaServerConfig
+}
+
+// refresh implements refresher.
+func (e embyRefresher) refresh(ctx context.Context, paths []string) error {
+	baseUrl := strings.TrimSuffix(e.config.Url, "/")
+	if len(paths) == 0 {
+		return e.post(ctx, baseUrl+"/Library/Refresh", nil)
+	}
+	updates := make([]mediaUpdate, len(paths))
+	for i, p := range paths {
+		updates[i] = mediaUpdate{Path: p, UpdateType: "Created"}
+	}
+	body, err := json.Marshal(mediaUpdatedRequest{Updates: updates})
+	if err != nil {
+		return err
+	}
+	return e.post(ctx, baseUrl+"/Library/Media/Updated", body)
+}
+
+func (e embyRefresher) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set(embyTokenHeader, e.config.Token)
+	rsp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(rsp)
+}
@@ -0,0 +1,174 @@
+package mediaserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/events"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+const (
+	defaultDebounce time.Duration = 30 * time.Second
+	requestTimeout  time.Duration = 30 * time.Second
+	// A full library refresh is triggered instead if more paths than this are pending
+	maxScopedPaths int = 10
+	// Audiobookshelf is notified about single files, so books split into many chapters are still scanned selectively
+	maxScopedFiles int = 500
+)
+
+// refresher triggers a library scan on a media server
+type refresher interface {
+	// refresh scans the given paths, or the whole library if paths is empty
+	refresh(ctx context.Context, paths []string) error
+}
+
+// refreshListener collects the paths of imported jobs of a category and refreshes them in batches
+type refreshListener struct {
+	category  models.MediaCategory
+	name      string
+	refresher refresher
+	basePath  string
+	debounce  time.Duration
+	// scope maps the imported files of a job to the paths passed to the refresher
+	scope    func(imported []string) []string
+	maxPaths int
+	batch    *refreshBatch
+}
+
+// refreshBatch holds the paths waiting for the next refresh
+type refreshBatch struct {
+	mu      sync.Mutex
+	pending map[string]struct{}
+	timer   *time.Timer
+}
+
+// NewListeners creates an event listener for every media server configured for a category
+func NewListeners(pathConfig config.PathConfig, categories config.CategoriesConfig) []events.Listener {
+	client := &http.Client{Timeout: requestTimeout}
+	listeners := []events.Listener{}
+	for category, categoryConfig := range categories {
+		for _, m := range categoryConfig.MediaServers {
+			var r refresher
+			scope, maxPaths := topLevelPaths, maxScopedPaths
+			switch m.Type {
+			case config.Jellyfin, config.Emby:
+				r = embyRefresher{client: client, config: m}
+			case config.Plex:
+				r = plexRefresher{client: client, config: m}
+			case config.Audiobookshelf:
+				r = audiobookshelfRefresher{client: client, config: m}
+				scope, maxPaths = slices.Clone[[]string], maxScopedFiles
+			default:
+				continue
+			}
+			basePath := m.Path
+			if len(basePath) == 0 {
+				basePath = pathConfig.DestinationFor(category)
+			}
+			debounce := m.Debounce
+			if debounce == 0 {
+				debounce = defaultDebounce
+			}
+			listeners = append(listeners, refreshListener{
+				category:  category,
+				name:      fmt.Sprintf("%s (%s)", m.Type, m.Url),
+				refresher: r,
+				basePath:  basePath,
+				debounce:  debounce,
+				scope:     scope,
+				maxPaths:  maxPaths,
+				batch:     &refreshBatch{pending: map[string]struct{}{}},
+			})
+		}
+	}
+	return listeners
+}
+
+// Handle implements events.Listener.
+func (r refreshListener) Handle(ctx context.Context, event events.Event) {
+	if event.Type != models.ImportedEvent || event.Job.Category != r.category || len(event.Job.Result.Imported) == 0 {
+		return
+	}
+
+	r.batch.mu.Lock()
+	defer r.batch.mu.Unlock()
+	for _, p := range r.scope(event.Job.Result.Imported) {
+		r.batch.pending[path.Join(r.basePath, filepath.ToSlash(p))] = struct{}{}
+	}
+	// The first import of a batch starts the timer, later ones are refreshed along with it
+	if r.batch.timer == nil && ctx.Err() == nil {
+		r.batch.timer = time.AfterFunc(r.debounce, func() { r.flush(ctx) })
+	}
+}
+
+// Run implements events.Runner. The pending refresh is stopped once the service shuts down.
+func (r refreshListener) Run(ctx context.Context) {
+	<-ctx.Done()
+	r.batch.mu.Lock()
+	defer r.batch.mu.Unlock()
+	if r.batch.timer != nil {
+		r.batch.timer.Stop()
+		r.batch.timer = nil
+	}
+	clear(r.batch.pending)
+}
+
+func (r refreshListener) flush(ctx context.Context) {
+	if ctx.Err() != nil {
+		return
+	}
+	r.batch.mu.Lock()
+	paths := make([]string, 0, len(r.batch.pending))
+	for p := range r.batch.pending {
+		paths = append(paths, p)
+	}
+	clear(r.batch.pending)
+	r.batch.timer = nil
+	r.batch.mu.Unlock()
+
+	slices.Sort(paths)
+	if len(paths) > r.maxPaths {
+		paths = nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	if err := r.refresher.refresh(ctx, paths); err != nil {
+		log.Printf("Refreshing %s library of %s failed: %s", r.category, r.name, err)
+		return
+	}
+	log.Printf("Refreshed %s library of %s", r.category, r.name)
+}
+
+// topLevelPaths returns the distinct top level folders of the imported files.
+// Files imported directly into the destination are represented by the destination itself.
+func topLevelPaths(imported []string) []string {
+	paths := []string{}
+	for _, i := range imported {
+		first, _, isDir := strings.Cut(filepath.ToSlash(i), "/")
+		if !isDir {
+			first = ""
+		}
+		if !slices.Contains(paths, first) {
+			paths = append(paths, first)
+		}
+	}
+	return paths
+}
+
+func checkResponse(rsp *http.Response) error {
+	rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", rsp.Status)
+	}
+	return nil
+}
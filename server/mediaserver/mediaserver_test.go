@@ -0,0 +1,88 @@
+package mediaserver
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/events"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+const testDebounce time.Duration = 20 * time.Millisecond
+
+type fakeRefresher struct {
+	mu        *sync.Mutex
+	refreshed [][]string
+}
+
+func (f *fakeRefresher) refresh(ctx context.Context, paths []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refreshed = append(f.refreshed, paths)
+	return nil
+}
+
+func (f *fakeRefresher) calls() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.refreshed)
+}
+
+func newTestListener() (refreshListener, *fakeRefresher) {
+	r := &fakeRefresher{mu: &sync.Mutex{}}
+	return refreshListener{
+		category:  models.Movies,
+		name:      "test",
+		refresher: r,
+		basePath:  "/media/movies",
+		debounce:  testDebounce,
+		scope:     topLevelPaths,
+		maxPaths:  maxScopedPaths,
+		batch:     &refreshBatch{pending: map[string]struct{}{}},
+	}, r
+}
+
+func importedEvent(files ...string) events.Event {
+	return events.Event{
+		Type: models.ImportedEvent,
+		Job:  models.Job{Category: models.Movies, Result: models.ImportResult{Imported: files}},
+	}
+}
+
+func TestRefreshBatchesImports(t *testing.T) {
+	listener, refresher := newTestListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listener.Handle(ctx, importedEvent("Movie (2019)/Movie (2019).mkv"))
+	listener.Handle(ctx, importedEvent("Other (2020)/Other (2020).mkv", "Other (2020)/Other (2020).srt"))
+	time.Sleep(5 * testDebounce)
+
+	want := [][]string{{"/media/movies/Movie (2019)", "/media/movies/Other (2020)"}}
+	if got := refresher.calls(); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("refreshed %q, want %q", got, want)
+	}
+}
+
+func TestRefreshStopsOnShutdown(t *testing.T) {
+	listener, refresher := newTestListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		listener.Run(ctx)
+	}()
+
+	listener.Handle(ctx, importedEvent("Movie (2019)/Movie (2019).mkv"))
+	cancel()
+	<-stopped
+	listener.Handle(ctx, importedEvent("Other (2020)/Other (2020).mkv"))
+	time.Sleep(5 * testDebounce)
+
+	if got := refresher.calls(); len(got) != 0 {
+		t.Errorf("refreshed %q after shutdown, want nothing", got)
+	}
+}
@@ -0,0 +1,51 @@
+package mediaserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bongofriend/torrent-ingest/config"
+)
+
+const (
+	plexTokenHeader string = "X-Plex-Token"
+)
+
+type plexRefresher struct {
+	client *http.Client
+	config config.MediaServerConfig
+}
+
+// refresh implements refresher. Paths are only scoped if a library section is configured.
+func (p plexRefresher) refresh(ctx context.Context, paths []string) error {
+	baseUrl := strings.TrimSuffix(p.config.Url, "/")
+	if len(p.config.Section) == 0 {
+		return p.get(ctx, baseUrl+"/library/sections/all/refresh")
+	}
+	sectionUrl := fmt.Sprintf("%s/library/sections/%s/refresh", baseUrl, url.PathEscape(p.config.Section))
+	if len(paths) == 0 {
+		return p.get(ctx, sectionUrl)
+	}
+	var errs []error
+	for _, path := range paths {
+		errs = append(errs, p.get(ctx, sectionUrl+"?path="+url.QueryEscape(path)))
+	}
+	return errors.Join(errs...)
+}
+
+func (p plexRefresher) get(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(plexTokenHeader, p.config.Token)
+	rsp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(rsp)
+}
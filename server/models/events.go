@@ -0,0 +1,18 @@
+package models
+
+import validation "github.com/go-ozzo/ozzo-validation"
+
+type EventType string
+
+const (
+	SubmittedEvent EventType = "submitted"
+	CompletedEvent EventType = "completed"
+	FailedEvent    EventType = "failed"
+	ImportedEvent  EventType = "imported"
+	// LowSpaceEvent is emitted when the import of a job is paused as its destination is running out of space
+	LowSpaceEvent EventType = "low_space"
+)
+
+func (e EventType) Validate() error {
+	return validation.Validate(string(e), validation.Required, validation.In(string(SubmittedEvent), string(CompletedEvent), string(FailedEvent), string(ImportedEvent), string(LowSpaceEvent)))
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+type JobSource string
+
+const (
+	TorrentJob JobSource = "torrent"
+	YtdlpJob   JobSource = "ytdlp"
+)
+
+type JobStatus string
+
+const (
+	JobQueued   JobStatus = "queued"
+	JobRunning  JobStatus = "running"
+	JobImported JobStatus = "imported"
+	JobFailed   JobStatus = "failed"
+)
+
+type StageName string
+
+const (
+	FilterStage    StageName = "filter"
+	ExtractStage   StageName = "extract"
+	VerifyStage    StageName = "verify"
+	TagStage       StageName = "tag"
+	AudiobookStage StageName = "audiobook"
+	TranscodeStage StageName = "transcode"
+	RenameStage    StageName = "rename"
+	SubtitleStage  StageName = "subtitle"
+	MetadataStage  StageName = "metadata"
+	TransferStage  StageName = "transfer"
+)
+
+func (s StageName) Validate() error {
+	return validation.Validate(string(s), validation.Required, validation.In(string(FilterStage), string(ExtractStage), string(VerifyStage), string(TagStage), string(AudiobookStage), string(TranscodeStage), string(RenameStage), string(SubtitleStage), string(MetadataStage), string(TransferStage)))
+}
+
+// SkippedFile is a file which was left out of an import
+type SkippedFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// ConflictFile is a file of an import which already existed in the destination
+type ConflictFile struct {
+	Path string `json:"path"`
+	// Resolution describes how the conflict was resolved, e.g. "overwritten" or "renamed to Movie (2).mkv"
+	Resolution string `json:"resolution"`
+}
+
+// ImportResult summarises which files of a download ended up in the library
+type ImportResult struct {
+	Imported []string      `json:"imported"`
+	Skipped  []SkippedFile `json:"skipped"`
+	// Conflicts are the files which already existed in the destination
+	Conflicts []ConflictFile `json:"conflicts,omitempty"`
+}
+
+// Metadata describes a downloaded file as reported by its source, e.g. the info dict of yt-dlp
+type Metadata struct {
+	Title         string `json:"title,omitempty"`
+	Artist        string `json:"artist,omitempty"`
+	AlbumArtist   string `json:"albumArtist,omitempty"`
+	Album         string `json:"album,omitempty"`
+	Genre         string `json:"genre,omitempty"`
+	Year          int    `json:"year,omitempty"`
+	Track         int    `json:"track,omitempty"`
+	Disc          int    `json:"disc,omitempty"`
+	Channel       string `json:"channel,omitempty"`
+	Playlist      string `json:"playlist,omitempty"`
+	PlaylistIndex int    `json:"playlistIndex,omitempty"`
+	Description   string `json:"description,omitempty"`
+	// UploadDate is formatted as YYYYMMDD
+	UploadDate string   `json:"uploadDate,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+}
+
+// JobFile is a file which is imported as part of a job
+type JobFile struct {
+	// Source is the path of the file on disk
+	Source string `json:"source"`
+	// Target is the path relative to the destination of the job
+	Target   string    `json:"target"`
+	Size     int64     `json:"size"`
+	Metadata *Metadata `json:"metadata,omitempty"`
+}
+
+// FileProgress is the progress of a long running stage on a single file
+type FileProgress struct {
+	File    string  `json:"file"`
+	Percent float64 `json:"percent"`
+}
+
+// StageResult records the outcome of a single post-processing stage
+type StageResult struct {
+	Stage    StageName     `json:"stage"`
+	Success  bool          `json:"success"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// HookResult records the outcome of an external hook command
+type HookResult struct {
+	Hook     string        `json:"hook"`
+	Command  string        `json:"command"`
+	ExitCode int           `json:"exitCode"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Job is a finished download which is post-processed and imported into the library
+type Job struct {
+	Id          string        `json:"id"`
+	Source      JobSource     `json:"source"`
+	Name        string        `json:"name"`
+	Category    MediaCategory `json:"category"`
+	Status      JobStatus     `json:"status"`
+	Destination string        `json:"destination"`
+	// WorkDir is a scratch directory for stages which produce intermediate files
+	WorkDir string        `json:"-"`
+	Files   []JobFile     `json:"files"`
+	Result  ImportResult  `json:"result"`
+	Stages  []StageResult `json:"stages"`
+	// Progress is reported by the running stage if it processes files for a longer time
+	Progress []FileProgress `json:"progress,omitempty"`
+	Hooks    []HookResult   `json:"hooks"`
+	// Paused is the reason the job is waiting before it can continue, e.g. low disk space
+	Paused   string    `json:"paused,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitzero"`
+}
+
+func NewJob(source JobSource, name string, category MediaCategory) *Job {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return &Job{
+		Id:       hex.EncodeToString(id),
+		Source:   source,
+		Name:     name,
+		Category: category,
+		Status:   JobQueued,
+		Started:  time.Now(),
+	}
+}
+
+// Clone returns a copy of the job which does not share any slices with the original
+func (j *Job) Clone() Job {
+	c := *j
+	c.Files = slices.Clone(j.Files)
+	c.Result.Imported = slices.Clone(j.Result.Imported)
+	c.Result.Skipped = slices.Clone(j.Result.Skipped)
+	c.Result.Conflicts = slices.Clone(j.Result.Conflicts)
+	c.Stages = slices.Clone(j.Stages)
+	c.Progress = slices.Clone(j.Progress)
+	c.Hooks = slices.Clone(j.Hooks)
+	return c
+}
@@ -0,0 +1,245 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/events"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+const (
+	defaultDigestTime string = "08:00"
+)
+
+// mailer delivers plain text mails via SMTP
+type mailer struct {
+	config config.EmailConfig
+	// rootCAs verify the certificate of the server, the system roots are used if nil
+	rootCAs *x509.CertPool
+}
+
+func (m mailer) sendMail(ctx context.Context, subject string, body string) error {
+	addr := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	tlsConfig := &tls.Config{ServerName: m.config.Host, RootCAs: m.rootCAs}
+	dialer := &net.Dialer{Timeout: requestTimeout}
+
+	var conn net.Conn
+	var err error
+	if m.config.Security == config.SmtpTls {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(time.Minute))
+	}
+
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if m.config.Security == "" || m.config.Security == config.SmtpStartTls {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if len(m.config.Username) > 0 {
+		if err := client.Auth(smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(m.config.From); err != nil {
+		return err
+	}
+	for _, to := range m.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m.message(subject, body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (m mailer) message(subject string, body string) []byte {
+	id := make([]byte, 12)
+	_, _ = rand.Read(id)
+	domain := m.config.From[strings.LastIndex(m.config.From, "@")+1:]
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(m.config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return msg.Bytes()
+}
+
+type emailSender struct {
+	mailer mailer
+}
+
+// send implements sender.
+func (e emailSender) send(ctx context.Context, n notification) error {
+	return e.mailer.sendMail(ctx, n.Title, n.Message)
+}
+
+// emailDigest collects imported and failed jobs and mails a summary once a day
+type emailDigest struct {
+	mailer mailer
+	at     time.Time
+	mu     *sync.Mutex
+	events *[]events.Event
+}
+
+func newEmailDigest(m mailer, digestConfig config.EmailDigestConfig) (emailDigest, error) {
+	t := digestConfig.Time
+	if len(t) == 0 {
+		t = defaultDigestTime
+	}
+	at, err := time.Parse("15:04", t)
+	if err != nil {
+		return emailDigest{}, err
+	}
+	return emailDigest{
+		mailer: m,
+		at:     at,
+		mu:     &sync.Mutex{},
+		events: &[]events.Event{},
+	}, nil
+}
+
+// Handle implements events.Listener.
+func (e emailDigest) Handle(ctx context.Context, event events.Event) {
+	if event.Type != models.ImportedEvent && event.Type != models.FailedEvent {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	*e.events = append(*e.events, event)
+}
+
+// Run implements events.Runner.
+func (e emailDigest) Run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(time.Until(e.next(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			e.send(ctx, now)
+		}
+	}
+}
+
+// next returns the next point in time the digest is due after now
+func (e emailDigest) next(now time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), e.at.Hour(), e.at.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func (e emailDigest) send(ctx context.Context, now time.Time) {
+	e.mu.Lock()
+	collected := *e.events
+	*e.events = []events.Event{}
+	e.mu.Unlock()
+	if len(collected) == 0 {
+		return
+	}
+
+	subject := fmt.Sprintf("Torrent ingest digest for %s", now.Format(time.DateOnly))
+	if err := e.mailer.sendMail(ctx, subject, digestBody(collected)); err != nil {
+		log.Printf("Sending email digest failed: %s", err)
+		// Keep the events for the next digest
+		e.mu.Lock()
+		*e.events = append(collected, *e.events...)
+		e.mu.Unlock()
+	}
+}
+
+func digestBody(collected []events.Event) string {
+	imported := map[models.MediaCategory][]models.Job{}
+	failed := []models.Job{}
+	for _, e := range collected {
+		if e.Type == models.FailedEvent {
+			failed = append(failed, e.Job)
+		} else {
+			imported[e.Job.Category] = append(imported[e.Job.Category], e.Job)
+		}
+	}
+	categories := make([]models.MediaCategory, 0, len(imported))
+	for c := range imported {
+		categories = append(categories, c)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i] < categories[j] })
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Imported: %d job(s)\n", len(collected)-len(failed))
+	for _, c := range categories {
+		fmt.Fprintf(&body, "\n%s\n", c)
+		for _, j := range imported[c] {
+			fmt.Fprintf(&body, "  - %s (%d file(s))\n", j.Name, len(j.Result.Imported))
+		}
+	}
+	fmt.Fprintf(&body, "\nFailed: %d job(s)\n", len(failed))
+	for _, j := range failed {
+		fmt.Fprintf(&body, "  - %s [%s]: %s\n", j.Name, j.Category, j.Error)
+	}
+	return body.String()
+}
+
+func newEmailListeners(emailConfig config.EmailConfig, t messageTemplates) ([]events.Listener, error) {
+	m := mailer{config: emailConfig}
+	listeners := []events.Listener{}
+	if len(emailConfig.Events) > 0 {
+		listeners = append(listeners, newNotificationListener("email", emailSender{mailer: m}, config.NotifierConfig{Events: emailConfig.Events}, t))
+	}
+	if emailConfig.Digest.Enabled {
+		digest, err := newEmailDigest(m, emailConfig.Digest)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, digest)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,377 @@
+package notify
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/events"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+// receivedMail is a mail accepted by the fake SMTP server
+type receivedMail struct {
+	tls  bool
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// fakeSmtpServer accepts mails on a local port. In starttls mode the STARTTLS extension is offered, in tls mode connections are encrypted from the start.
+type fakeSmtpServer struct {
+	listener  net.Listener
+	security  config.SmtpSecurity
+	tlsConfig *tls.Config
+	mails     chan receivedMail
+}
+
+func startSmtpServer(t *testing.T, security config.SmtpSecurity) (*fakeSmtpServer, *x509.CertPool) {
+	cert, rootCAs := selfSignedCertificate(t)
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if security == config.SmtpTls {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server := &fakeSmtpServer{
+		listener:  listener,
+		security:  security,
+		tlsConfig: tlsConfig,
+		mails:     make(chan receivedMail, 10),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server, rootCAs
+}
+
+func (f *fakeSmtpServer) config() config.EmailConfig {
+	addr := f.listener.Addr().(*net.TCPAddr)
+	return config.EmailConfig{
+		Host:     "127.0.0.1",
+		Port:     addr.Port,
+		Security: f.security,
+		Username: "user",
+		Password: "secret",
+		From:     "ingest@example.com",
+		To:       []string{"a@example.com", "b@example.com"},
+	}
+}
+
+func (f *fakeSmtpServer) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	received := receivedMail{tls: f.security == config.SmtpTls}
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, argument, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(command) {
+		case "EHLO":
+			text.PrintfLine("250-localhost")
+			if f.security == config.SmtpStartTls && !received.tls {
+				text.PrintfLine("250-STARTTLS")
+			}
+			text.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			if f.security != config.SmtpStartTls {
+				text.PrintfLine("502 not supported")
+				continue
+			}
+			text.PrintfLine("220 ready")
+			tlsConn := tls.Server(conn, f.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			received.tls = true
+		case "AUTH":
+			credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(argument, "PLAIN "))
+			received.auth = string(credentials)
+			text.PrintfLine("235 authenticated")
+		case "MAIL":
+			received.from = strings.Trim(strings.TrimPrefix(argument, "FROM:"), "<>")
+			text.PrintfLine("250 ok")
+		case "RCPT":
+			received.to = append(received.to, strings.Trim(strings.TrimPrefix(argument, "TO:"), "<>"))
+			text.PrintfLine("250 ok")
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			received.data = string(data)
+			f.mails <- received
+			text.PrintfLine("250 queued")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("502 unknown command")
+		}
+	}
+}
+
+func selfSignedCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, rootCAs
+}
+
+// parseMail returns the decoded subject and the body of a received mail. Line endings are normalised to "\n" by the server.
+func parseMail(t *testing.T, received receivedMail) (string, string) {
+	msg, err := mail.ReadMessage(strings.NewReader(received.data))
+	if err != nil {
+		t.Fatalf("mail can not be parsed: %s", err)
+	}
+	subject, err := (&mime.WordDecoder{}).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(msg.Body)
+	return subject, string(body)
+}
+
+func nextMail(t *testing.T, server *fakeSmtpServer) receivedMail {
+	select {
+	case received := <-server.mails:
+		return received
+	default:
+		t.Fatal("no mail was received")
+		return receivedMail{}
+	}
+}
+
+func importedEvent(name string, category models.MediaCategory, files ...string) events.Event {
+	return events.Event{
+		Type: models.ImportedEvent,
+		Time: time.Now(),
+		Job:  models.Job{Id: name, Name: name, Category: category, Result: models.ImportResult{Imported: files}},
+	}
+}
+
+func failedEvent(name string, category models.MediaCategory, err string) events.Event {
+	return events.Event{
+		Type: models.FailedEvent,
+		Time: time.Now(),
+		Job:  models.Job{Id: name, Name: name, Category: category, Error: err},
+	}
+}
+
+func TestSendMail(t *testing.T) {
+	for _, security := range []config.SmtpSecurity{config.SmtpNone, config.SmtpStartTls, config.SmtpTls} {
+		t.Run(string(security), func(t *testing.T) {
+			server, rootCAs := startSmtpServer(t, security)
+			m := mailer{config: server.config(), rootCAs: rootCAs}
+
+			if err := m.sendMail(context.Background(), "Imported Movie", "line 1\nline 2"); err != nil {
+				t.Fatalf("sendMail() failed: %s", err)
+			}
+
+			received := nextMail(t, server)
+			if received.tls != (security != config.SmtpNone) {
+				t.Errorf("mail sent with TLS %v in %s mode", received.tls, security)
+			}
+			if received.auth != "\x00user\x00secret" {
+				t.Errorf("auth = %q, want plain credentials", received.auth)
+			}
+			if received.from != "ingest@example.com" || strings.Join(received.to, ",") != "a@example.com,b@example.com" {
+				t.Errorf("envelope from %s to %v, want the configured addresses", received.from, received.to)
+			}
+			subject, body := parseMail(t, received)
+			if subject != "Imported Movie" {
+				t.Errorf("subject = %q, want %q", subject, "Imported Movie")
+			}
+			if body != "line 1\nline 2\n" {
+				t.Errorf("body = %q, want %q", body, "line 1\nline 2\n")
+			}
+		})
+	}
+}
+
+func TestSendMailRequiresStartTls(t *testing.T) {
+	server, rootCAs := startSmtpServer(t, config.SmtpNone)
+	emailConfig := server.config()
+	emailConfig.Security = ""
+	m := mailer{config: emailConfig, rootCAs: rootCAs}
+
+	if err := m.sendMail(context.Background(), "subject", "body"); err == nil {
+		t.Error("sendMail() succeeded on a server without STARTTLS")
+	}
+	if len(server.mails) != 0 {
+		t.Error("mail was sent without STARTTLS")
+	}
+}
+
+func TestSendMailRejectsUnknownCertificate(t *testing.T) {
+	server, _ := startSmtpServer(t, config.SmtpStartTls)
+	m := mailer{config: server.config()}
+
+	if err := m.sendMail(context.Background(), "subject", "body"); err == nil {
+		t.Error("sendMail() succeeded with an untrusted certificate")
+	}
+}
+
+func TestEmailPerEvent(t *testing.T) {
+	server, rootCAs := startSmtpServer(t, config.SmtpStartTls)
+	templates, err := newTemplates(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mailer{config: server.config(), rootCAs: rootCAs}
+	listener := newNotificationListener("email", emailSender{mailer: m}, config.NotifierConfig{Events: []models.EventType{models.ImportedEvent}}, templates)
+
+	listener.Handle(context.Background(), failedEvent("Ignored", models.Movies, "boom"))
+	listener.Handle(context.Background(), importedEvent("Movie (2020)", models.Movies, "a.mkv", "b.srt"))
+
+	subject, body := parseMail(t, nextMail(t, server))
+	if subject != "Imported Movie (2020)" {
+		t.Errorf("subject = %q, want %q", subject, "Imported Movie (2020)")
+	}
+	if body != "2 file(s) imported into movies\n" {
+		t.Errorf("body = %q", body)
+	}
+	if len(server.mails) != 0 {
+		t.Errorf("got %d further mails, want only the configured event", len(server.mails))
+	}
+}
+
+func TestEmailDigestFlush(t *testing.T) {
+	server, rootCAs := startSmtpServer(t, config.SmtpStartTls)
+	digest, err := newEmailDigest(mailer{config: server.config(), rootCAs: rootCAs}, config.EmailDigestConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	now := time.Date(2026, 10, 19, 8, 0, 0, 0, time.Local)
+
+	digest.Handle(ctx, events.Event{Type: models.SubmittedEvent, Job: models.Job{Name: "Submitted"}})
+	digest.Handle(ctx, importedEvent("Show S01E01", models.Series, "a.mkv"))
+	digest.Handle(ctx, importedEvent("Movie", models.Movies, "a.mkv", "b.mkv"))
+	digest.Handle(ctx, failedEvent("Album", models.Music, "no space left"))
+	digest.send(ctx, now)
+
+	subject, body := parseMail(t, nextMail(t, server))
+	if subject != "Torrent ingest digest for 2026-10-19" {
+		t.Errorf("subject = %q", subject)
+	}
+	want := strings.Join([]string{
+		"Imported: 2 job(s)",
+		"",
+		"movies",
+		"  - Movie (2 file(s))",
+		"",
+		"series",
+		"  - Show S01E01 (1 file(s))",
+		"",
+		"Failed: 1 job(s)",
+		"  - Album [music]: no space left",
+		"",
+	}, "\n")
+	if body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+
+	// The collected events are cleared once the digest was sent
+	digest.send(ctx, now.AddDate(0, 0, 1))
+	if len(server.mails) != 0 {
+		t.Errorf("got %d mails without new events, want 0", len(server.mails))
+	}
+}
+
+func TestEmailDigestKeepsEventsOnFailure(t *testing.T) {
+	server, rootCAs := startSmtpServer(t, config.SmtpStartTls)
+	unreachable := server.config()
+	unreachable.Port = 1
+	failing, err := newEmailDigest(mailer{config: unreachable}, config.EmailDigestConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	failing.Handle(ctx, importedEvent("Movie", models.Movies, "a.mkv"))
+	failing.send(ctx, time.Now())
+	failing.Handle(ctx, importedEvent("Other", models.Movies, "b.mkv"))
+
+	working := failing
+	working.mailer = mailer{config: server.config(), rootCAs: rootCAs}
+	working.send(ctx, time.Now())
+
+	_, body := parseMail(t, nextMail(t, server))
+	if !strings.Contains(body, "Imported: 2 job(s)") || !strings.Contains(body, "  - Movie (1 file(s))") {
+		t.Errorf("body = %q, want the events of the failed digest", body)
+	}
+}
+
+func TestEmailDigestNext(t *testing.T) {
+	digest, err := newEmailDigest(mailer{}, config.EmailDigestConfig{Time: "08:30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC)},
+		{time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC), time.Date(2026, 10, 20, 8, 30, 0, 0, time.UTC)},
+		{time.Date(2026, 12, 31, 23, 0, 0, 0, time.UTC), time.Date(2027, 1, 1, 8, 30, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		if got := digest.next(test.now); !got.Equal(test.want) {
+			t.Errorf("next(%s) = %s, want %s", test.now, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/bongofriend/torrent-ingest/config"
+)
+
+const (
+	gotifyTokenHeader string = "X-Gotify-Key"
+)
+
+type gotifyMessage struct {
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority"`
+}
+
+type gotifySender struct {
+	client *http.Client
+	config config.GotifyConfig
+}
+
+// send implements sender.
+func (g gotifySender) send(ctx context.Context, notification notification) error {
+	body, err := json.Marshal(gotifyMessage{
+		Title:    notification.Title,
+		Message:  notification.Message,
+		Priority: notification.Priority,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(g.config.Url, "/")+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(gotifyTokenHeader, g.config.Token)
+	rsp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(rsp)
+}
@@ -0,0 +1,46 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/bongofriend/torrent-ingest/config"
+)
+
+func TestGotifySend(t *testing.T) {
+	server, requests := notificationServer(t, http.StatusOK)
+	g := gotifySender{client: server.Client(), config: config.GotifyConfig{Url: server.URL + "/", Token: "app-token"}}
+
+	if err := g.send(context.Background(), testNotification()); err != nil {
+		t.Fatalf("send() failed: %s", err)
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*requests))
+	}
+	received := (*requests)[0]
+	if received.method != http.MethodPost || received.path != "/message" {
+		t.Errorf("request = %s %s, want POST /message", received.method, received.path)
+	}
+	if got := received.header.Get(gotifyTokenHeader); got != "app-token" {
+		t.Errorf("%s = %q, want %q", gotifyTokenHeader, got, "app-token")
+	}
+	message := gotifyMessage{}
+	if err := json.Unmarshal(received.body, &message); err != nil {
+		t.Fatal(err)
+	}
+	if want := (gotifyMessage{Title: "Imported Movie", Message: "2 files", Priority: 4}); message != want {
+		t.Errorf("message = %+v, want %+v", message, want)
+	}
+}
+
+func TestGotifySendFails(t *testing.T) {
+	server, _ := notificationServer(t, http.StatusUnauthorized)
+	g := gotifySender{client: server.Client(), config: config.GotifyConfig{Url: server.URL, Token: "wrong"}}
+
+	if err := g.send(context.Background(), testNotification()); err == nil {
+		t.Error("send() succeeded with an invalid token, want an error")
+	}
+}
@@ -0,0 +1,174 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"text/template"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/events"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+const (
+	requestTimeout time.Duration = 10 * time.Second
+)
+
+var (
+	defaultEvents = []models.EventType{models.ImportedEvent, models.FailedEvent, models.LowSpaceEvent}
+
+	defaultTemplates = map[models.EventType]config.NotificationTemplateConfig{
+		models.SubmittedEvent: {
+			Title:   "Submitted {{.Job.Name}}",
+			Message: "{{.Job.Source}} download for {{.Job.Category}} was submitted",
+		},
+		models.CompletedEvent: {
+			Title:   "Downloaded {{.Job.Name}}",
+			Message: "Download finished, importing into {{.Job.Category}}",
+		},
+		models.ImportedEvent: {
+			Title:   "Imported {{.Job.Name}}",
+			Message: "{{len .Job.Result.Imported}} file(s) imported into {{.Job.Category}}",
+		},
+		models.FailedEvent: {
+			Title:   "Failed {{.Job.Name}}",
+			Message: "{{.Job.Error}}",
+		},
+		models.LowSpaceEvent: {
+			Title:   "Paused {{.Job.Name}}",
+			Message: "{{.Job.Paused}}",
+		},
+	}
+)
+
+// notification is a rendered message ready to be sent by a provider
+type notification struct {
+	Event    models.EventType
+	Title    string
+	Message  string
+	Priority int
+}
+
+type sender interface {
+	send(ctx context.Context, n notification) error
+}
+
+type messageTemplates struct {
+	title   map[models.EventType]*template.Template
+	message map[models.EventType]*template.Template
+}
+
+func newTemplates(templateConfig map[models.EventType]config.NotificationTemplateConfig) (messageTemplates, error) {
+	t := messageTemplates{
+		title:   map[models.EventType]*template.Template{},
+		message: map[models.EventType]*template.Template{},
+	}
+	for eventType, d := range defaultTemplates {
+		if c, ok := templateConfig[eventType]; ok {
+			if len(c.Title) > 0 {
+				d.Title = c.Title
+			}
+			if len(c.Message) > 0 {
+				d.Message = c.Message
+			}
+		}
+		var err error
+		if t.title[eventType], err = template.New(string(eventType)).Parse(d.Title); err != nil {
+			return t, err
+		}
+		if t.message[eventType], err = template.New(string(eventType)).Parse(d.Message); err != nil {
+			return t, err
+		}
+	}
+	return t, nil
+}
+
+func (r messageTemplates) render(event events.Event) (string, string, error) {
+	var title, message bytes.Buffer
+	if err := r.title[event.Type].Execute(&title, event); err != nil {
+		return "", "", err
+	}
+	if err := r.message[event.Type].Execute(&message, event); err != nil {
+		return "", "", err
+	}
+	return title.String(), message.String(), nil
+}
+
+type notificationListener struct {
+	name       string
+	sender     sender
+	events     []models.EventType
+	priorities map[models.EventType]int
+	templates  messageTemplates
+}
+
+func newNotificationListener(name string, s sender, notifierConfig config.NotifierConfig, t messageTemplates) notificationListener {
+	eventTypes := notifierConfig.Events
+	if len(eventTypes) == 0 {
+		eventTypes = defaultEvents
+	}
+	return notificationListener{
+		name:       name,
+		sender:     s,
+		events:     eventTypes,
+		priorities: notifierConfig.Priorities,
+		templates:  t,
+	}
+}
+
+// Handle implements events.Listener.
+func (n notificationListener) Handle(ctx context.Context, event events.Event) {
+	if !slices.Contains(n.events, event.Type) {
+		return
+	}
+	title, message, err := n.templates.render(event)
+	if err != nil {
+		log.Printf("Rendering %s notification for job %s failed: %s", event.Type, event.Job.Id, err)
+		return
+	}
+	if err := n.sender.send(ctx, notification{
+		Event:    event.Type,
+		Title:    title,
+		Message:  message,
+		Priority: n.priorities[event.Type],
+	}); err != nil {
+		log.Printf("Sending %s notification for job %s via %s failed: %s", event.Type, event.Job.Id, n.name, err)
+	}
+}
+
+// NewListeners creates an event listener for every configured notification provider
+func NewListeners(notificationsConfig config.NotificationsConfig) ([]events.Listener, error) {
+	t, err := newTemplates(notificationsConfig.Templates)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: requestTimeout}
+	listeners := []events.Listener{}
+	for _, c := range notificationsConfig.Ntfy {
+		listeners = append(listeners, newNotificationListener("ntfy", ntfySender{client: client, config: c}, c.NotifierConfig, t))
+	}
+	for _, c := range notificationsConfig.Gotify {
+		listeners = append(listeners, newNotificationListener("gotify", gotifySender{client: client, config: c}, c.NotifierConfig, t))
+	}
+	for _, c := range notificationsConfig.Email {
+		emailListeners, err := newEmailListeners(c, t)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, emailListeners...)
+	}
+	return listeners, nil
+}
+
+func checkResponse(rsp *http.Response) error {
+	rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", rsp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/bongofriend/torrent-ingest/config"
+)
+
+type ntfyMessage struct {
+	Topic    string   `json:"topic"`
+	Title    string   `json:"title"`
+	Message  string   `json:"message"`
+	Priority int      `json:"priority,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+type ntfySender struct {
+	client *http.Client
+	config config.NtfyConfig
+}
+
+// send implements sender.
+func (n ntfySender) send(ctx context.Context, notification notification) error {
+	body, err := json.Marshal(ntfyMessage{
+		Topic:    n.config.Topic,
+		Title:    notification.Title,
+		Message:  notification.Message,
+		Priority: notification.Priority,
+		Tags:     []string{string(notification.Event)},
+	})
+	if err != nil {
+		return err
+	}
+	// JSON messages are published to the root URL of the ntfy server
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(n.config.Url, "/"), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.config.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+n.config.Token)
+	}
+	rsp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(rsp)
+}
@@ -0,0 +1,90 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+// recordedRequest is a request received by a fake notification server
+type recordedRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// notificationServer records the requests it receives and answers with status
+func notificationServer(t *testing.T, status int) (*httptest.Server, *[]recordedRequest) {
+	requests := &[]recordedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*requests = append(*requests, recordedRequest{method: r.Method, path: r.URL.Path, header: r.Header.Clone(), body: body})
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func testNotification() notification {
+	return notification{Event: models.ImportedEvent, Title: "Imported Movie", Message: "2 files", Priority: 4}
+}
+
+func TestNtfySend(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		auth  string
+	}{
+		{"with token", "tk_secret", "Bearer tk_secret"},
+		{"without token", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests := notificationServer(t, http.StatusOK)
+			n := ntfySender{client: server.Client(), config: config.NtfyConfig{Url: server.URL + "/", Topic: "downloads", Token: test.token}}
+
+			if err := n.send(context.Background(), testNotification()); err != nil {
+				t.Fatalf("send() failed: %s", err)
+			}
+
+			if len(*requests) != 1 {
+				t.Fatalf("got %d requests, want 1", len(*requests))
+			}
+			received := (*requests)[0]
+			if received.method != http.MethodPost || received.path != "/" {
+				t.Errorf("request = %s %s, want POST to the root URL", received.method, received.path)
+			}
+			if got := received.header.Get("Authorization"); got != test.auth {
+				t.Errorf("Authorization = %q, want %q", got, test.auth)
+			}
+			if got := received.header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			message := ntfyMessage{}
+			if err := json.Unmarshal(received.body, &message); err != nil {
+				t.Fatal(err)
+			}
+			want := ntfyMessage{Topic: "downloads", Title: "Imported Movie", Message: "2 files", Priority: 4, Tags: []string{string(models.ImportedEvent)}}
+			if !reflect.DeepEqual(message, want) {
+				t.Errorf("message = %+v, want %+v", message, want)
+			}
+		})
+	}
+}
+
+func TestNtfySendFails(t *testing.T) {
+	server, _ := notificationServer(t, http.StatusForbidden)
+	n := ntfySender{client: server.Client(), config: config.NtfyConfig{Url: server.URL, Topic: "downloads"}}
+
+	if err := n.send(context.Background(), testNotification()); err == nil {
+		t.Error("send() succeeded for a rejected message, want an error")
+	}
+}
@@ -0,0 +1,237 @@
+package postprocess
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+const (
+	defaultAudiobookBitrate string = "64k"
+	// Cue sheets count time in frames of 1/75 seconds
+	cueFramesPerSecond float64 = 75
+)
+
+var (
+	cueTrackPattern = regexp.MustCompile(`^\s*TRACK\s+\d+`)
+	cueTitlePattern = regexp.MustCompile(`^\s*TITLE\s+"?(.*?)"?\s*$`)
+	cueIndexPattern = regexp.MustCompile(`^\s*INDEX\s+01\s+(\d+):(\d{2}):(\d{2})`)
+	numberRunes     = regexp.MustCompile(`\d+|\D+`)
+)
+
+type chapter struct {
+	title string
+	// start and end are offsets in seconds
+	start float64
+	end   float64
+}
+
+// audiobookStage assembles the audio files of a book into a single M4B file with chapters
+type audiobookStage struct {
+	bitrate string
+}
+
+func newAudiobookStage(categoryConfig config.CategoryConfig) Stage {
+	audiobookConfig := categoryConfig.Audiobook
+	if !audiobookConfig.Enabled {
+		return nil
+	}
+	bitrate := audiobookConfig.Bitrate
+	if len(bitrate) == 0 {
+		bitrate = defaultAudiobookBitrate
+	}
+	return audiobookStage{bitrate: bitrate}
+}
+
+// Name implements Stage.
+func (a audiobookStage) Name() models.StageName {
+	return models.AudiobookStage
+}
+
+// Run implements Stage.
+func (a audiobookStage) Run(ctx context.Context, job *models.Job) error {
+	parts, cueSheet, cover := []models.JobFile{}, "", ""
+	for _, fi := range job.Files {
+		switch {
+		case hasExtension(fi.Target, audioExtensions):
+			parts = append(parts, fi)
+		case strings.EqualFold(path.Ext(fi.Target), ".cue"):
+			cueSheet = fi.Source
+		case hasExtension(fi.Target, imageExtensions) && len(cover) == 0:
+			cover = fi.Source
+		}
+	}
+	// A single file is only converted if a cue sheet provides its chapters
+	if len(parts) == 0 || (len(parts) == 1 && len(cueSheet) == 0) {
+		return nil
+	}
+	slices.SortFunc(parts, func(a models.JobFile, b models.JobFile) int {
+		return naturalCompare(a.Target, b.Target)
+	})
+
+	chapters, err := chaptersOf(ctx, parts, cueSheet)
+	if err != nil {
+		return err
+	}
+	book := audiobookFor(job)
+	dir := filepath.Join(job.WorkDir, "audiobook")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	output := filepath.Join(dir, sanitizeFileName(book.Title)+".m4b")
+	if err := a.assemble(ctx, dir, parts, chapters, book.Author, book.Title, cover, output); err != nil {
+		return err
+	}
+
+	// The parts and cue sheet are replaced by the assembled book, other files like the cover are kept
+	target := path.Join(path.Dir(filepath.ToSlash(parts[0].Target)), path.Base(output))
+	files := []models.JobFile{{
+		Source:   output,
+		Target:   target,
+		Metadata: &models.Metadata{Title: book.Title, Album: book.Title, Artist: book.Author, AlbumArtist: book.Author},
+	}}
+	for _, fi := range job.Files {
+		isPart := hasExtension(fi.Target, audioExtensions) || strings.EqualFold(path.Ext(fi.Target), ".cue")
+		if !isPart {
+			files = append(files, fi)
+			continue
+		}
+		job.Result.Skipped = append(job.Result.Skipped, models.SkippedFile{Path: fi.Target, Reason: "assembled into " + target})
+	}
+	job.Files = files
+	return nil
+}
+
+func (a audiobookStage) assemble(ctx context.Context, dir string, parts []models.JobFile, chapters []chapter, author string, title string, cover string, output string) error {
+	list := strings.Builder{}
+	for _, p := range parts {
+		// Paths of the concat demuxer are quoted with single quotes which are escaped as '\''
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(p.Source, "'", `'\''`))
+	}
+	listFile := filepath.Join(dir, "parts.txt")
+	if err := os.WriteFile(listFile, []byte(list.String()), 0644); err != nil {
+		return err
+	}
+	metadataFile := filepath.Join(dir, "metadata.txt")
+	if err := os.WriteFile(metadataFile, []byte(ffmetadata(author, title, chapters)), 0644); err != nil {
+		return err
+	}
+
+	args := []string{"-f", "concat", "-safe", "0", "-i", listFile, "-i", metadataFile}
+	if len(cover) > 0 {
+		args = append(args, "-i", cover)
+	}
+	args = append(args, "-map", "0:a", "-map_metadata", "1", "-map_chapters", "1")
+	if len(cover) > 0 {
+		args = append(args, "-map", "2:v", "-c:v", "copy", "-disposition:v:0", "attached_pic")
+	}
+	args = append(args, "-c:a", "aac", "-b:a", a.bitrate, "-movflags", "+faststart", "-f", "mp4", output)
+	return runFfmpeg(ctx, args...)
+}
+
+// chaptersOf returns the chapters of a book, read from the cue sheet of a single file or one chapter per part
+func chaptersOf(ctx context.Context, parts []models.JobFile, cueSheet string) ([]chapter, error) {
+	chapters := []chapter{}
+	offset := 0.0
+	for _, p := range parts {
+		probed, err := probe(ctx, p.Source)
+		if err != nil {
+			return nil, err
+		}
+		duration := probed.duration()
+		if len(parts) == 1 && len(cueSheet) > 0 {
+			return readCueSheet(cueSheet, duration)
+		}
+		title := probed.tag("title")
+		if len(title) == 0 {
+			name := path.Base(filepath.ToSlash(p.Target))
+			title = strings.TrimSuffix(name, path.Ext(name))
+		}
+		chapters = append(chapters, chapter{title: title, start: offset, end: offset + duration})
+		offset += duration
+	}
+	return chapters, nil
+}
+
+// readCueSheet reads the tracks of a cue sheet as chapters, the last one ends with the file
+func readCueSheet(cueSheet string, duration float64) ([]chapter, error) {
+	f, err := os.Open(cueSheet)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	chapters := []chapter{}
+	inTrack := false
+	title := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case cueTrackPattern.MatchString(line):
+			inTrack, title = true, ""
+		case inTrack && cueTitlePattern.MatchString(line):
+			title = cueTitlePattern.FindStringSubmatch(line)[1]
+		case inTrack && cueIndexPattern.MatchString(line):
+			m := cueIndexPattern.FindStringSubmatch(line)
+			minutes, _ := strconv.Atoi(m[1])
+			seconds, _ := strconv.Atoi(m[2])
+			frames, _ := strconv.Atoi(m[3])
+			start := float64(minutes*60+seconds) + float64(frames)/cueFramesPerSecond
+			if n := len(chapters); n > 0 {
+				chapters[n-1].end = start
+			}
+			if len(title) == 0 {
+				title = fmt.Sprintf("Chapter %d", len(chapters)+1)
+			}
+			chapters = append(chapters, chapter{title: title, start: start, end: duration})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(chapters) == 0 {
+		return nil, fmt.Errorf("cue sheet %s contains no tracks", filepath.Base(cueSheet))
+	}
+	return chapters, nil
+}
+
+// ffmetadata renders the metadata and chapters in the metadata format of ffmpeg
+func ffmetadata(author string, title string, chapters []chapter) string {
+	escape := strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", `\`+"\n").Replace
+	b := strings.Builder{}
+	b.WriteString(";FFMETADATA1\n")
+	fmt.Fprintf(&b, "title=%s\nalbum=%s\nartist=%s\nalbum_artist=%s\ngenre=Audiobook\n", escape(title), escape(title), escape(author), escape(author))
+	for _, c := range chapters {
+		fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n", int64(c.start*1000), int64(c.end*1000), escape(c.title))
+	}
+	return b.String()
+}
+
+// naturalCompare orders paths with numbers by their value, so "Part 2" comes before "Part 10"
+func naturalCompare(a string, b string) int {
+	aParts, bParts := numberRunes.FindAllString(a, -1), numberRunes.FindAllString(b, -1)
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNumber, aErr := strconv.Atoi(aParts[i])
+		bNumber, bErr := strconv.Atoi(bParts[i])
+		if aErr == nil && bErr == nil {
+			if aNumber != bNumber {
+				return aNumber - bNumber
+			}
+			continue
+		}
+		if c := strings.Compare(strings.ToLower(aParts[i]), strings.ToLower(bParts[i])); c != 0 {
+			return c
+		}
+	}
+	return len(aParts) - len(bParts)
+}
@@ -0,0 +1,394 @@
+package postprocess
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/models"
+	"github.com/nwaples/rardecode/v2"
+	"github.com/ulikunitz/xz"
+)
+
+type ArchiveFormat string
+
+const (
+	Rar      ArchiveFormat = "rar"
+	Zip      ArchiveFormat = "zip"
+	SevenZip ArchiveFormat = "7z"
+	Tar      ArchiveFormat = "tar"
+)
+
+const (
+	archivePlaceholder     string = "{archive}"
+	destinationPlaceholder string = "{dest}"
+)
+
+var (
+	errUnsafeArchiveEntry = errors.New("archive entry escapes extraction directory")
+
+	// Default command for formats which can not be extracted in Go
+	defaultExtractCommand = []string{"7z", "x", "-y", "-o" + destinationPlaceholder, archivePlaceholder}
+)
+
+type volumePattern struct {
+	format  ArchiveFormat
+	pattern *regexp.Regexp
+	// isFirst reports if the matched file is the volume extraction has to start from
+	isFirst func(match []string) bool
+}
+
+func firstVolume([]string) bool { return true }
+
+func otherVolume([]string) bool { return false }
+
+func volumeNumberIsOne(match []string) bool {
+	n, err := strconv.Atoi(match[2])
+	return err == nil && n == 1
+}
+
+// Patterns are matched against lower case file names in order, the first group is the name of the archive set
+var volumePatterns = []volumePattern{
+	{Rar, regexp.MustCompile(`^(.+)\.part(\d+)\.rar$`), volumeNumberIsOne},
+	{Rar, regexp.MustCompile(`^(.+)\.rar$`), firstVolume},
+	{Rar, regexp.MustCompile(`^(.+)\.[rs](\d{2,3})$`), otherVolume},
+	{SevenZip, regexp.MustCompile(`^(.+)\.7z\.(\d{3})$`), volumeNumberIsOne},
+	{SevenZip, regexp.MustCompile(`^(.+)\.7z$`), firstVolume},
+	{Zip, regexp.MustCompile(`^(.+)\.zip$`), firstVolume},
+	{Tar, regexp.MustCompile(`^(.+)\.(tar|tar\.gz|tgz|tar\.xz|txz|tar\.bz2|tbz2?)$`), firstVolume},
+}
+
+// ArchiveSet is an archive which might be split into several volumes
+type ArchiveSet struct {
+	Format ArchiveFormat
+	// First is the relative path of the volume extraction starts from
+	First string
+	// Volumes contains the relative paths of all volumes of the set, including the first one
+	Volumes []string
+}
+
+// FindArchiveSets groups the given relative paths into archive sets.
+// Sets without a first volume are incomplete and not returned.
+func FindArchiveSets(relPaths []string) []ArchiveSet {
+	sets := map[string]*ArchiveSet{}
+	keys := []string{}
+	for _, p := range relPaths {
+		slashed := filepath.ToSlash(p)
+		dir, name := path.Split(slashed)
+		lower := strings.ToLower(name)
+		for _, vp := range volumePatterns {
+			match := vp.pattern.FindStringSubmatch(lower)
+			if match == nil {
+				continue
+			}
+			key := string(vp.format) + ":" + dir + match[1]
+			set, ok := sets[key]
+			if !ok {
+				set = &ArchiveSet{Format: vp.format}
+				sets[key] = set
+				keys = append(keys, key)
+			}
+			set.Volumes = append(set.Volumes, p)
+			if vp.isFirst(match) {
+				set.First = p
+			}
+			break
+		}
+	}
+
+	result := []ArchiveSet{}
+	for _, k := range keys {
+		set := sets[k]
+		if len(set.First) == 0 {
+			continue
+		}
+		sort.Strings(set.Volumes)
+		result = append(result, *set)
+	}
+	return result
+}
+
+// Extractor unpacks archive sets, in Go where possible or by running an external tool
+type Extractor struct {
+	command []string
+}
+
+func NewExtractor(extractConfig config.ExtractConfig) Extractor {
+	return Extractor{
+		command: extractConfig.Command,
+	}
+}
+
+// Extract unpacks the archive set whose first volume is located at archivePath into destDir.
+// The paths of the extracted files relative to destDir are returned.
+func (e Extractor) Extract(ctx context.Context, format ArchiveFormat, archivePath string, destDir string) ([]string, error) {
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	var err error
+	switch {
+	case len(e.command) > 0:
+		err = runExtractCommand(ctx, e.command, archivePath, destDir)
+	case format == Rar:
+		err = extractRar(archivePath, destDir)
+	case format == Zip:
+		err = extractZip(archivePath, destDir)
+	case format == Tar:
+		err = extractTar(archivePath, destDir)
+	default:
+		err = runExtractCommand(ctx, defaultExtractCommand, archivePath, destDir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("extracting %s: %w", archivePath, err)
+	}
+	return listFiles(destDir)
+}
+
+func runExtractCommand(ctx context.Context, command []string, archivePath string, destDir string) error {
+	args := make([]string, len(command)-1)
+	for i, a := range command[1:] {
+		a = strings.ReplaceAll(a, archivePlaceholder, archivePath)
+		args[i] = strings.ReplaceAll(a, destinationPlaceholder, destDir)
+	}
+	output, err := exec.CommandContext(ctx, command[0], args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func extractRar(archivePath string, destDir string) error {
+	r, err := rardecode.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for {
+		header, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.LinkType != 0 {
+			continue
+		}
+		if err := writeEntry(destDir, header.Name, header.IsDir, header.Mode(), r); err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(archivePath string, destDir string) error {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.Mode()&fs.ModeSymlink != 0 {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeEntry(destDir, f.Name, f.FileInfo().IsDir(), f.Mode(), rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTar(archivePath string, destDir string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	lower := strings.ToLower(archivePath)
+	switch {
+	case strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz"):
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	case strings.HasSuffix(lower, ".xz") || strings.HasSuffix(lower, ".txz"):
+		if reader, err = xz.NewReader(file); err != nil {
+			return err
+		}
+	case strings.HasSuffix(lower, ".bz2") || strings.HasSuffix(lower, ".tbz") || strings.HasSuffix(lower, ".tbz2"):
+		reader = bzip2.NewReader(file)
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = writeEntry(destDir, header.Name, true, 0, nil)
+		case tar.TypeReg:
+			err = writeEntry(destDir, header.Name, false, header.FileInfo().Mode(), tr)
+		default:
+			// Links and special files are not extracted
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeEntry writes a single archive entry below destDir, rejecting entries which would end up outside of it
+func writeEntry(destDir string, name string, isDir bool, mode fs.FileMode, content io.Reader) error {
+	target, err := safeJoin(destDir, name)
+	if err != nil {
+		return err
+	}
+	if isDir {
+		return os.MkdirAll(target, 0o755)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	perm := mode.Perm()
+	if perm == 0 {
+		perm = 0o644
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, content); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func safeJoin(root string, name string) (string, error) {
+	name = filepath.FromSlash(strings.ReplaceAll(name, "\\", "/"))
+	if filepath.IsAbs(name) || !filepath.IsLocal(name) {
+		return "", fmt.Errorf("%w: %s", errUnsafeArchiveEntry, name)
+	}
+	return filepath.Join(root, name), nil
+}
+
+// listFiles returns the relative paths of all regular files below root
+func listFiles(root string) ([]string, error) {
+	files := []string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	return files, err
+}
+
+type extractStage struct {
+	extractor    Extractor
+	skipArchives bool
+}
+
+func newExtractStage(categoryConfig config.CategoryConfig) Stage {
+	if !categoryConfig.Extract.Enabled {
+		return nil
+	}
+	return extractStage{
+		extractor:    NewExtractor(categoryConfig.Extract),
+		skipArchives: categoryConfig.Extract.SkipArchives,
+	}
+}
+
+// Name implements Stage.
+func (e extractStage) Name() models.StageName {
+	return models.ExtractStage
+}
+
+// Run implements Stage.
+func (e extractStage) Run(ctx context.Context, job *models.Job) error {
+	sources := make(map[string]string, len(job.Files))
+	targets := make([]string, len(job.Files))
+	for i, fi := range job.Files {
+		sources[fi.Target] = fi.Source
+		targets[i] = fi.Target
+	}
+
+	archives := map[string]struct{}{}
+	for i, set := range FindArchiveSets(targets) {
+		outDir := filepath.Join(job.WorkDir, "extract", strconv.Itoa(i))
+		extracted, err := e.extractor.Extract(ctx, set.Format, sources[set.First], outDir)
+		if err != nil {
+			// Keep the archives so nothing is lost if extraction fails
+			log.Println(err)
+			continue
+		}
+		for _, x := range extracted {
+			source := filepath.Join(outDir, x)
+			info, err := os.Stat(source)
+			if err != nil {
+				return err
+			}
+			job.Files = append(job.Files, models.JobFile{
+				Source: source,
+				Target: filepath.Join(filepath.Dir(set.First), x),
+				Size:   info.Size(),
+			})
+		}
+		if e.skipArchives {
+			for _, v := range set.Volumes {
+				archives[v] = struct{}{}
+			}
+		}
+	}
+
+	kept := job.Files[:0]
+	for _, fi := range job.Files {
+		if _, ok := archives[fi.Target]; ok {
+			job.Result.Skipped = append(job.Result.Skipped, models.SkippedFile{Path: fi.Target, Reason: "archive was extracted"})
+			continue
+		}
+		kept = append(kept, fi)
+	}
+	job.Files = kept
+	return nil
+}
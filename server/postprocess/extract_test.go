@@ -0,0 +1,86 @@
+package postprocess
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindArchiveSets(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		want  []ArchiveSet
+	}{
+		{
+			name:  "no archives",
+			paths: []string{"movie.mkv", "movie.nfo"},
+			want:  []ArchiveSet{},
+		},
+		{
+			name:  "rar parts",
+			paths: []string{"Movie/movie.part02.rar", "Movie/movie.part01.rar", "Movie/movie.part03.rar", "Movie/movie.nfo"},
+			want: []ArchiveSet{
+				{Rar, "Movie/movie.part01.rar", []string{"Movie/movie.part01.rar", "Movie/movie.part02.rar", "Movie/movie.part03.rar"}},
+			},
+		},
+		{
+			name:  "rar parts with single digit",
+			paths: []string{"movie.part1.rar", "movie.part2.rar"},
+			want: []ArchiveSet{
+				{Rar, "movie.part1.rar", []string{"movie.part1.rar", "movie.part2.rar"}},
+			},
+		},
+		{
+			name:  "old style rar volumes",
+			paths: []string{"movie.r01", "movie.rar", "movie.r00", "movie.s00"},
+			want: []ArchiveSet{
+				{Rar, "movie.rar", []string{"movie.r00", "movie.r01", "movie.rar", "movie.s00"}},
+			},
+		},
+		{
+			name:  "upper case names",
+			paths: []string{"MOVIE.R00", "MOVIE.RAR"},
+			want: []ArchiveSet{
+				{Rar, "MOVIE.RAR", []string{"MOVIE.R00", "MOVIE.RAR"}},
+			},
+		},
+		{
+			name:  "split 7z",
+			paths: []string{"backup.7z.002", "backup.7z.001", "backup.7z.003"},
+			want: []ArchiveSet{
+				{SevenZip, "backup.7z.001", []string{"backup.7z.001", "backup.7z.002", "backup.7z.003"}},
+			},
+		},
+		{
+			name:  "single archives",
+			paths: []string{"a.7z", "b.zip", "c.tar.gz", "d.tbz2"},
+			want: []ArchiveSet{
+				{SevenZip, "a.7z", []string{"a.7z"}},
+				{Zip, "b.zip", []string{"b.zip"}},
+				{Tar, "c.tar.gz", []string{"c.tar.gz"}},
+				{Tar, "d.tbz2", []string{"d.tbz2"}},
+			},
+		},
+		{
+			name:  "sets in different folders",
+			paths: []string{"CD1/movie.rar", "CD1/movie.r00", "CD2/movie.rar", "CD2/movie.r00"},
+			want: []ArchiveSet{
+				{Rar, "CD1/movie.rar", []string{"CD1/movie.r00", "CD1/movie.rar"}},
+				{Rar, "CD2/movie.rar", []string{"CD2/movie.r00", "CD2/movie.rar"}},
+			},
+		},
+		{
+			name:  "missing first volume",
+			paths: []string{"movie.part02.rar", "movie.part03.rar", "backup.7z.002", "other.r00"},
+			want:  []ArchiveSet{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := FindArchiveSets(test.paths)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("FindArchiveSets(%v) = %v, want %v", test.paths, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package postprocess
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// probeResult is the output of ffprobe for a media file
+type probeResult struct {
+	Format  probeFormat   `json:"format"`
+	Streams []probeStream `json:"streams"`
+}
+
+type probeFormat struct {
+	Duration string            `json:"duration"`
+	Tags     map[string]string `json:"tags"`
+}
+
+type probeStream struct {
+	Index       int               `json:"index"`
+	CodecType   string            `json:"codec_type"`
+	CodecName   string            `json:"codec_name"`
+	Tags        map[string]string `json:"tags"`
+	Disposition struct {
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+}
+
+// probe reads the streams and tags of a media file with ffprobe
+func probe(ctx context.Context, file string) (probeResult, error) {
+	output, err := exec.CommandContext(ctx, "ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", file).Output()
+	if err != nil {
+		return probeResult{}, fmt.Errorf("probing %s: %w", file, err)
+	}
+	result := probeResult{}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return probeResult{}, fmt.Errorf("probing %s: %w", file, err)
+	}
+	return result, nil
+}
+
+// duration returns the length of the file in seconds
+func (p probeResult) duration() float64 {
+	d, _ := strconv.ParseFloat(p.Format.Duration, 64)
+	return d
+}
+
+// tag returns the first of the given tags found in the file. Tags are compared case insensitive
+// and ignoring underscores as their names differ between ID3, Vorbis comments and MP4.
+func (p probeResult) tag(names ...string) string {
+	tags := map[string]string{}
+	for _, s := range p.Streams {
+		// Ogg files carry their tags on the audio stream instead of the container
+		for k, v := range s.Tags {
+			tags[normalizeTagName(k)] = v
+		}
+	}
+	for k, v := range p.Format.Tags {
+		tags[normalizeTagName(k)] = v
+	}
+	for _, n := range names {
+		if v := strings.TrimSpace(tags[normalizeTagName(n)]); len(v) > 0 {
+			return v
+		}
+	}
+	return ""
+}
+
+func normalizeTagName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "_", "")
+}
+
+// runFfmpeg runs ffmpeg with the given arguments, the output of ffmpeg is included in the returned error
+func runFfmpeg(ctx context.Context, args ...string) error {
+	args = append([]string{"-hide_banner", "-nostdin", "-y", "-v", "error"}, args...)
+	output, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// runFfmpegWithProgress runs ffmpeg like runFfmpeg and reports the share of duration processed so far in percent
+func runFfmpegWithProgress(ctx context.Context, duration float64, progress func(percent float64), args ...string) error {
+	args = append([]string{"-hide_banner", "-nostdin", "-y", "-v", "error", "-nostats", "-progress", "pipe:1"}, args...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("ffmpeg: %w", err)
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		// The progress is written as key=value lines, out_time_us is the position in the output in microseconds
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		if key != "out_time_us" || duration <= 0 {
+			continue
+		}
+		if us, err := strconv.ParseFloat(value, 64); err == nil {
+			progress(min(100, us/1e6/duration*100))
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	progress(100)
+	return nil
+}
@@ -0,0 +1,123 @@
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+var (
+	// Matches sample directories and files named like "sample.mkv", "sample-movie.mkv" or "Movie-sample.mkv".
+	// "sample" in the middle of a name is ignored as it is likely part of the title.
+	samplePattern = regexp.MustCompile(`(?i)(^|/)sample(/|$)|(^|/)sample[._\- ]|[._\- ]sample$`)
+)
+
+// FileFilter decides which files of a download are imported into the library
+type FileFilter struct {
+	allowExtensions map[string]struct{}
+	denyExtensions  map[string]struct{}
+	allowPatterns   []string
+	denyPatterns    []string
+	minSize         int64
+	skipSamples     bool
+}
+
+func NewFileFilter(filterConfig config.FilterConfig) FileFilter {
+	return FileFilter{
+		allowExtensions: normalizeExtensions(filterConfig.AllowExtensions),
+		denyExtensions:  normalizeExtensions(filterConfig.DenyExtensions),
+		allowPatterns:   filterConfig.AllowPatterns,
+		denyPatterns:    filterConfig.DenyPatterns,
+		minSize:         int64(filterConfig.MinSize),
+		skipSamples:     filterConfig.SkipSamples,
+	}
+}
+
+// Check evaluates the filter rules for a file relative to the download root.
+// If the file should be skipped, the reason is returned together with false.
+func (f FileFilter) Check(relPath string, size int64) (string, bool) {
+	relPath = filepath.ToSlash(relPath)
+	ext := strings.ToLower(path.Ext(relPath))
+
+	if _, denied := f.denyExtensions[ext]; denied {
+		return fmt.Sprintf("extension %s is denied", ext), false
+	}
+	if pattern, ok := matchAny(f.denyPatterns, relPath); ok {
+		return fmt.Sprintf("matches deny pattern %s", pattern), false
+	}
+	if len(f.allowExtensions) > 0 || len(f.allowPatterns) > 0 {
+		_, allowedExt := f.allowExtensions[ext]
+		_, allowedPattern := matchAny(f.allowPatterns, relPath)
+		if !allowedExt && !allowedPattern {
+			return "not matched by any allow rule", false
+		}
+	}
+	if f.minSize > 0 && size < f.minSize {
+		return fmt.Sprintf("smaller than %s", config.ByteSize(f.minSize)), false
+	}
+	if f.skipSamples && samplePattern.MatchString(strings.TrimSuffix(relPath, path.Ext(relPath))) {
+		return "detected as sample", false
+	}
+	return "", true
+}
+
+// matchAny matches the patterns against the full relative path as well as the file name
+func matchAny(patterns []string, relPath string) (string, bool) {
+	name := path.Base(relPath)
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, relPath); ok {
+			return p, true
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+func normalizeExtensions(extensions []string) map[string]struct{} {
+	normalized := make(map[string]struct{}, len(extensions))
+	for _, e := range extensions {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		normalized[e] = struct{}{}
+	}
+	return normalized
+}
+
+type filterStage struct {
+	filter FileFilter
+}
+
+func newFilterStage(categoryConfig config.CategoryConfig) Stage {
+	return filterStage{
+		filter: NewFileFilter(categoryConfig.Filter),
+	}
+}
+
+// Name implements Stage.
+func (f filterStage) Name() models.StageName {
+	return models.FilterStage
+}
+
+// Run implements Stage.
+func (f filterStage) Run(ctx context.Context, job *models.Job) error {
+	kept := job.Files[:0]
+	for _, fi := range job.Files {
+		if reason, ok := f.filter.Check(fi.Target, fi.Size); !ok {
+			job.Result.Skipped = append(job.Result.Skipped, models.SkippedFile{Path: fi.Target, Reason: reason})
+			continue
+		}
+		kept = append(kept, fi)
+	}
+	job.Files = kept
+	return nil
+}
@@ -0,0 +1,48 @@
+package postprocess
+
+import (
+	"testing"
+
+	"github.com/bongofriend/torrent-ingest/config"
+)
+
+func TestFileFilterCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  config.FilterConfig
+		path    string
+		size    int64
+		allowed bool
+	}{
+		{"no rules", config.FilterConfig{}, "Movie/movie.mkv", 100, true},
+		{"denied extension", config.FilterConfig{DenyExtensions: []string{"nfo"}}, "Movie/movie.nfo", 100, false},
+		{"denied extension ignores case", config.FilterConfig{DenyExtensions: []string{".NFO"}}, "Movie/movie.Nfo", 100, false},
+		{"allowed extension", config.FilterConfig{AllowExtensions: []string{"mkv"}}, "Movie/movie.mkv", 100, true},
+		{"not allowed extension", config.FilterConfig{AllowExtensions: []string{"mkv"}}, "Movie/movie.txt", 100, false},
+		{"deny pattern on name", config.FilterConfig{DenyPatterns: []string{"*.exe"}}, "Movie/setup.exe", 100, false},
+		{"deny pattern on path", config.FilterConfig{DenyPatterns: []string{"Movie/Extras/*"}}, "Movie/Extras/interview.mkv", 100, false},
+		{"deny pattern does not cross folders", config.FilterConfig{DenyPatterns: []string{"Extras/*"}}, "Movie/Extras/interview.mkv", 100, true},
+		{"deny wins over allow", config.FilterConfig{AllowExtensions: []string{"mkv"}, DenyPatterns: []string{"*trailer*"}}, "Movie/trailer.mkv", 100, false},
+		{"allow pattern", config.FilterConfig{AllowPatterns: []string{"*.en.srt"}}, "Movie/movie.en.srt", 100, true},
+		{"allow pattern or extension", config.FilterConfig{AllowExtensions: []string{"mkv"}, AllowPatterns: []string{"*.en.srt"}}, "Movie/movie.de.srt", 100, false},
+		{"character class", config.FilterConfig{DenyPatterns: []string{"CD[12]/*.nfo"}}, "CD2/info.nfo", 100, false},
+		{"smaller than minimum", config.FilterConfig{MinSize: 1000}, "Movie/movie.mkv", 999, false},
+		{"minimum size", config.FilterConfig{MinSize: 1000}, "Movie/movie.mkv", 1000, true},
+		{"sample file", config.FilterConfig{SkipSamples: true}, "Movie/movie-sample.mkv", 100, false},
+		{"sample folder", config.FilterConfig{SkipSamples: true}, "Movie/Sample/movie.mkv", 100, false},
+		{"sample prefix", config.FilterConfig{SkipSamples: true}, "Movie/sample.movie.mkv", 100, false},
+		{"sample inside title", config.FilterConfig{SkipSamples: true}, "The.Sample.Movie.mkv", 100, true},
+		{"samples kept", config.FilterConfig{}, "Movie/movie-sample.mkv", 100, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reason, allowed := NewFileFilter(test.filter).Check(test.path, test.size)
+			if allowed != test.allowed {
+				t.Errorf("Check(%q) = %v (%q), want %v", test.path, allowed, reason, test.allowed)
+			}
+			if !allowed && len(reason) == 0 {
+				t.Errorf("Check(%q) returned no reason", test.path)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package postprocess
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+const (
+	onCompleteHook     string        = "on_complete"
+	onFailureHook      string        = "on_failure"
+	defaultHookTimeout time.Duration = 1 * time.Minute
+	hookEnvPrefix      string        = "TORRENT_INGEST_JOB_"
+)
+
+// runHooks runs the hook matching the job status and records its outcome on the job
+func runHooks(ctx context.Context, hooksConfig config.HooksConfig, job *models.Job) {
+	hook, command := onCompleteHook, hooksConfig.OnComplete
+	if job.Status == models.JobFailed {
+		hook, command = onFailureHook, hooksConfig.OnFailure
+	}
+	if len(command) == 0 {
+		return
+	}
+	timeout := hooksConfig.Timeout
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+	job.Hooks = append(job.Hooks, runHook(ctx, hook, command, timeout, job))
+}
+
+func runHook(ctx context.Context, hook string, command []string, timeout time.Duration, job *models.Job) models.HookResult {
+	result := models.HookResult{
+		Hook:    hook,
+		Command: strings.Join(command, " "),
+	}
+	start := time.Now()
+	payload, err := json.Marshal(job.Clone())
+	if err != nil {
+		result.ExitCode = -1
+		result.Error = err.Error()
+		return result
+	}
+
+	// Hooks still run to completion if the job was cancelled by a shutdown
+	ctxWithTimeout, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctxWithTimeout, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), hookEnv(job)...)
+	cmd.Stdin = bytes.NewReader(payload)
+	output, err := cmd.CombinedOutput()
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		log.Printf("[%s %s] %s", hook, job.Id, scanner.Text())
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.Is(ctxWithTimeout.Err(), context.DeadlineExceeded):
+		result.ExitCode = -1
+		result.Error = fmt.Sprintf("timed out after %s", timeout)
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+		result.Error = err.Error()
+	default:
+		result.ExitCode = -1
+		result.Error = err.Error()
+	}
+	result.Duration = time.Since(start)
+	if len(result.Error) > 0 {
+		log.Printf("Hook %s for job %s failed: %s", hook, job.Id, result.Error)
+	}
+	return result
+}
+
+func hookEnv(job *models.Job) []string {
+	env := map[string]string{
+		"ID":          job.Id,
+		"SOURCE":      string(job.Source),
+		"NAME":        job.Name,
+		"CATEGORY":    string(job.Category),
+		"STATUS":      string(job.Status),
+		"DESTINATION": job.Destination,
+		"ERROR":       job.Error,
+		"IMPORTED":    strings.Join(job.Result.Imported, "\n"),
+	}
+	vars := make([]string, 0, len(env))
+	for k, v := range env {
+		vars = append(vars, hookEnvPrefix+k+"="+v)
+	}
+	return vars
+}
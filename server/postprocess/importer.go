@@ -0,0 +1,136 @@
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/diskspace"
+	"github.com/bongofriend/torrent-ingest/events"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+// Importer runs the post-processing pipeline of a job's category to import it into the library
+type Importer interface {
+	Import(ctx context.Context, job *models.Job) error
+	// Fail marks a job as failed which could not be imported because its download failed
+	Fail(ctx context.Context, job *models.Job, err error)
+}
+
+type importer struct {
+	pathConfig config.PathConfig
+	guard      diskspace.Guard
+	categories config.CategoriesConfig
+	store      JobStore
+	publisher  events.Publisher
+}
+
+func NewImporter(pathConfig config.PathConfig, guard diskspace.Guard, categories config.CategoriesConfig, store JobStore, publisher events.Publisher) Importer {
+	return importer{
+		pathConfig: pathConfig,
+		guard:      guard,
+		categories: categories,
+		store:      store,
+		publisher:  publisher,
+	}
+}
+
+// Import implements Importer.
+func (i importer) Import(ctx context.Context, job *models.Job) error {
+	job.Destination = i.pathConfig.DestinationFor(job.Category)
+	job.Status = models.JobRunning
+	i.store.Update(job)
+	i.publisher.Publish(models.CompletedEvent, job)
+
+	return i.finish(ctx, job, i.run(ctx, job))
+}
+
+// Fail implements Importer.
+func (i importer) Fail(ctx context.Context, job *models.Job, err error) {
+	job.Destination = i.pathConfig.DestinationFor(job.Category)
+	i.finish(ctx, job, err)
+}
+
+func (i importer) finish(ctx context.Context, job *models.Job, err error) error {
+	job.Finished = time.Now()
+	if err != nil {
+		job.Status = models.JobFailed
+		job.Error = err.Error()
+		err = fmt.Errorf("import of %s job %s (%s) failed: %w", job.Source, job.Id, job.Name, err)
+	} else {
+		job.Status = models.JobImported
+		log.Printf("Imported %d file(s) of %s job %s (%s) into %s", len(job.Result.Imported), job.Source, job.Id, job.Name, job.Destination)
+	}
+	for _, s := range job.Result.Skipped {
+		log.Printf(" - Skipped %s: %s", s.Path, s.Reason)
+	}
+	for _, c := range job.Result.Conflicts {
+		log.Printf(" - Conflict %s: %s", c.Path, c.Resolution)
+	}
+	runHooks(ctx, i.categories.For(job.Category).Hooks, job)
+	i.store.Update(job)
+	if job.Status == models.JobFailed {
+		i.publisher.Publish(models.FailedEvent, job)
+	} else {
+		i.publisher.Publish(models.ImportedEvent, job)
+	}
+	return err
+}
+
+func (i importer) run(ctx context.Context, job *models.Job) error {
+	if len(job.Destination) == 0 {
+		return fmt.Errorf("no destination configured for category %s", job.Category)
+	}
+	for idx, f := range job.Files {
+		// File names of torrents are chosen by their uploader and must not write outside of the destination
+		target, err := safeTarget(f.Target)
+		if err != nil {
+			return err
+		}
+		job.Files[idx].Target = target
+		if job.Source == models.TorrentJob && len(i.pathConfig.DownloadBasePath) > 0 {
+			if err := checkInside(i.pathConfig.DownloadBasePath, f.Source); err != nil {
+				return err
+			}
+		}
+		info, err := os.Stat(f.Source)
+		if err != nil {
+			return err
+		}
+		job.Files[idx].Size = info.Size()
+	}
+	if err := i.waitForSpace(ctx, job); err != nil {
+		return err
+	}
+
+	workDir, err := os.MkdirTemp("", "job*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+	job.WorkDir = workDir
+
+	pipeline := NewPipeline(i.categories.For(job.Category))
+	return pipeline.Run(ctx, job, i.store.Update)
+}
+
+// waitForSpace pauses the job until its destination has room for its files
+func (i importer) waitForSpace(ctx context.Context, job *models.Job) error {
+	size := int64(0)
+	for _, f := range job.Files {
+		size += f.Size
+	}
+	err := i.guard.WaitFor(ctx, job.Destination, size, func(err error) {
+		job.Paused = err.Error()
+		i.store.Update(job)
+		i.publisher.Publish(models.LowSpaceEvent, job)
+	})
+	if len(job.Paused) > 0 {
+		job.Paused = ""
+		i.store.Update(job)
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package postprocess
+
+import (
+	"context"
+	"encoding/xml"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+var (
+	videoExtensions = map[string]struct{}{".mp4": {}, ".mkv": {}, ".webm": {}, ".avi": {}, ".mov": {}, ".m4v": {}}
+	imageExtensions = map[string]struct{}{".jpg": {}, ".jpeg": {}, ".png": {}, ".webp": {}}
+)
+
+// nfo is the Kodi metadata format, which is read by Jellyfin and Emby as well
+type nfo struct {
+	XMLName   xml.Name
+	Title     string   `xml:"title"`
+	ShowTitle string   `xml:"showtitle,omitempty"`
+	Plot      string   `xml:"plot,omitempty"`
+	Aired     string   `xml:"aired,omitempty"`
+	Premiered string   `xml:"premiered,omitempty"`
+	Year      int      `xml:"year,omitempty"`
+	Studio    string   `xml:"studio,omitempty"`
+	Tags      []string `xml:"tag"`
+}
+
+// metadataStage writes sidecar files for the videos of a job from the metadata reported by yt-dlp
+type metadataStage struct {
+	nfo     bool
+	artwork bool
+}
+
+func newMetadataStage(categoryConfig config.CategoryConfig) Stage {
+	metadataConfig := categoryConfig.Metadata
+	if !metadataConfig.Nfo && !metadataConfig.Artwork {
+		return nil
+	}
+	return metadataStage{nfo: metadataConfig.Nfo, artwork: metadataConfig.Artwork}
+}
+
+// Name implements Stage.
+func (m metadataStage) Name() models.StageName {
+	return models.MetadataStage
+}
+
+// Run implements Stage.
+func (m metadataStage) Run(ctx context.Context, job *models.Job) error {
+	videos := videosByDir(job.Files)
+	sidecars := []models.JobFile{}
+	for i, fi := range job.Files {
+		if fi.Metadata == nil || !hasExtension(fi.Target, videoExtensions) {
+			continue
+		}
+		stem := strings.TrimSuffix(fi.Target, path.Ext(fi.Target))
+		if m.nfo {
+			source := filepath.Join(job.WorkDir, "metadata", strconv.Itoa(i)+".nfo")
+			if err := writeNfo(source, job.Category, *fi.Metadata); err != nil {
+				return err
+			}
+			sidecars = append(sidecars, models.JobFile{Source: source, Target: stem + ".nfo"})
+		}
+		if m.artwork {
+			// poster.jpg and fanart.jpg are shared by all files of a folder, so they are prefixed if the folder contains more than one video
+			prefix := path.Dir(fi.Target) + "/"
+			if videos[path.Dir(fi.Target)] > 1 {
+				prefix = stem + "-"
+			}
+			for j, image := range job.Files {
+				if !hasExtension(image.Target, imageExtensions) || strings.TrimSuffix(image.Target, path.Ext(image.Target)) != stem {
+					continue
+				}
+				ext := strings.ToLower(path.Ext(image.Target))
+				job.Files[j].Target = path.Clean(prefix + "poster" + ext)
+				sidecars = append(sidecars, models.JobFile{Source: image.Source, Target: path.Clean(prefix + "fanart" + ext), Size: image.Size})
+			}
+		}
+	}
+	job.Files = append(job.Files, sidecars...)
+	return nil
+}
+
+func writeNfo(filePath string, category models.MediaCategory, metadata models.Metadata) error {
+	n := nfo{
+		Title:  metadata.Title,
+		Plot:   metadata.Description,
+		Studio: metadata.Channel,
+		Tags:   metadata.Tags,
+	}
+	date := ""
+	if uploaded, err := time.Parse("20060102", metadata.UploadDate); err == nil {
+		date = uploaded.Format(time.DateOnly)
+		n.Year = uploaded.Year()
+	}
+	if category == models.Movies {
+		n.XMLName.Local = "movie"
+		n.Premiered = date
+	} else {
+		n.XMLName.Local = "episodedetails"
+		n.ShowTitle = metadata.Channel
+		n.Aired = date
+	}
+
+	content, err := xml.MarshalIndent(n, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, append([]byte(xml.Header), content...), 0644)
+}
+
+// videosByDir counts the videos per target folder
+func videosByDir(files []models.JobFile) map[string]int {
+	videos := map[string]int{}
+	for _, fi := range files {
+		if hasExtension(fi.Target, videoExtensions) {
+			videos[path.Dir(fi.Target)]++
+		}
+	}
+	return videos
+}
+
+func hasExtension(p string, extensions map[string]struct{}) bool {
+	_, ok := extensions[strings.ToLower(path.Ext(p))]
+	return ok
+}
@@ -0,0 +1,85 @@
+package postprocess
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errUnsafePath = errors.New("unsafe path")
+	// Device names which can not be used as file names on SMB shares, with or without extension
+	reservedNames = map[string]struct{}{
+		"CON": {}, "PRN": {}, "AUX": {}, "NUL": {},
+		"COM1": {}, "COM2": {}, "COM3": {}, "COM4": {}, "COM5": {}, "COM6": {}, "COM7": {}, "COM8": {}, "COM9": {},
+		"LPT1": {}, "LPT2": {}, "LPT3": {}, "LPT4": {}, "LPT5": {}, "LPT6": {}, "LPT7": {}, "LPT8": {}, "LPT9": {},
+	}
+)
+
+// safeTarget validates a target path relative to the destination. Absolute paths and ".." are rejected,
+// characters which are invalid on SMB shares are removed. Backslashes are treated as separators.
+func safeTarget(target string) (string, error) {
+	slashed := strings.ReplaceAll(target, "\\", "/")
+	if path.IsAbs(slashed) || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return "", fmt.Errorf("%w: %s is absolute", errUnsafePath, target)
+	}
+	elements := []string{}
+	for _, e := range strings.Split(slashed, "/") {
+		if e == ".." {
+			return "", fmt.Errorf("%w: %s leaves the destination", errUnsafePath, target)
+		}
+		if e == "" || e == "." {
+			continue
+		}
+		sanitized := sanitizePathElement(e)
+		if len(sanitized) == 0 {
+			return "", fmt.Errorf("%w: %s contains the invalid name %q", errUnsafePath, target, e)
+		}
+		elements = append(elements, sanitized)
+	}
+	if len(elements) == 0 {
+		return "", fmt.Errorf("%w: %q is empty", errUnsafePath, target)
+	}
+	return path.Join(elements...), nil
+}
+
+// sanitizePathElement removes control characters and characters reserved on SMB shares from a file or folder name,
+// as well as the trailing dots and spaces they do not allow. Reserved device names like "CON" are suffixed.
+func sanitizePathElement(e string) string {
+	e = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`:*?"<>|`, r) {
+			return -1
+		}
+		return r
+	}, e)
+	e = strings.TrimRight(e, ". ")
+	stem, _, _ := strings.Cut(e, ".")
+	if _, ok := reservedNames[strings.ToUpper(strings.TrimSpace(stem))]; ok {
+		e = stem + "_" + strings.TrimPrefix(e, stem)
+	}
+	return e
+}
+
+// checkInside returns an error if p, or its deepest existing parent, resolves to a location outside of root by following symlinks
+func checkInside(root string, p string) error {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	for existing := p; ; existing = filepath.Dir(existing) {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if errors.Is(err, fs.ErrNotExist) && filepath.Dir(existing) != existing {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if resolved != resolvedRoot && !isBelow(resolvedRoot, resolved) {
+			return fmt.Errorf("%w: %s resolves to %s outside of %s", errUnsafePath, p, resolved, root)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,132 @@
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+// Stage is a single step of the post-processing pipeline
+type Stage interface {
+	Name() models.StageName
+	Run(ctx context.Context, job *models.Job) error
+}
+
+// progressStage is a stage which reports the progress of its work on the job while it is running
+type progressStage interface {
+	Stage
+	// runWithProgress runs the stage like Run, report publishes the current state of the job
+	runWithProgress(ctx context.Context, job *models.Job, report func()) error
+}
+
+// stageFactory creates a stage for a category. Nil is returned if the stage has nothing to do for the category.
+type stageFactory func(categoryConfig config.CategoryConfig) Stage
+
+var stageFactories = map[models.StageName]stageFactory{
+	models.ExtractStage:   newExtractStage,
+	models.FilterStage:    newFilterStage,
+	models.VerifyStage:    newVerifyStage,
+	models.TagStage:       newTagStage,
+	models.AudiobookStage: newAudiobookStage,
+	models.TranscodeStage: newTranscodeStage,
+	models.RenameStage:    newRenameStage,
+	models.SubtitleStage:  newSubtitleStage,
+	models.MetadataStage:  newMetadataStage,
+	models.TransferStage:  newTransferStage,
+}
+
+// Archives are extracted before filtering so the filter rules apply to their contents as well
+var defaultStages = []models.StageName{
+	models.ExtractStage,
+	models.FilterStage,
+	models.VerifyStage,
+	models.TagStage,
+	models.AudiobookStage,
+	models.TranscodeStage,
+	models.RenameStage,
+	models.SubtitleStage,
+	models.MetadataStage,
+	models.TransferStage,
+}
+
+// Pipeline runs the configured stages of a category in order
+type Pipeline struct {
+	stages []Stage
+}
+
+func NewPipeline(categoryConfig config.CategoryConfig) Pipeline {
+	names := categoryConfig.Stages
+	if len(names) == 0 {
+		names = defaultStages
+	}
+	stages := []Stage{}
+	for _, n := range names {
+		factory, ok := stageFactories[n]
+		if !ok {
+			continue
+		}
+		if stage := factory(categoryConfig); stage != nil {
+			stages = append(stages, stage)
+		}
+	}
+	return Pipeline{
+		stages: stages,
+	}
+}
+
+// Run executes the stages on the job until one of them fails. afterStage is called once a stage has been recorded on the job
+// and whenever a stage reports its progress.
+func (p Pipeline) Run(ctx context.Context, job *models.Job, afterStage func(job *models.Job)) error {
+	for _, s := range p.stages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		start := time.Now()
+		var err error
+		if ps, ok := s.(progressStage); ok {
+			err = ps.runWithProgress(ctx, job, func() { afterStage(job) })
+			job.Progress = nil
+		} else {
+			err = s.Run(ctx, job)
+		}
+		result := models.StageResult{
+			Stage:    s.Name(),
+			Success:  err == nil,
+			Duration: time.Since(start),
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		job.Stages = append(job.Stages, result)
+		afterStage(job)
+		if err != nil {
+			return fmt.Errorf("stage %s failed: %w", s.Name(), err)
+		}
+	}
+	return nil
+}
+
+// FilesFromDir returns all regular files below root as job files targeting the same relative path
+func FilesFromDir(root string) ([]models.JobFile, error) {
+	files := []models.JobFile{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, models.JobFile{Source: p, Target: rel})
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,238 @@
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/models"
+	"github.com/bongofriend/torrent-ingest/release"
+)
+
+// renameStage moves the files of a job into the folder structure of the configured layout or template
+type renameStage struct {
+	layout   config.RenameLayout
+	template *release.Template
+}
+
+// animeLayoutTemplate files episodes into per-show folders, keeping the names of the fansub releases
+const animeLayoutTemplate string = "{Title}/{Original}.{ext}"
+
+func newRenameStage(categoryConfig config.CategoryConfig) Stage {
+	renameConfig := categoryConfig.Rename
+	switch {
+	case renameConfig.Layout == config.AnimeLayout:
+		template, _ := release.ParseTemplate(animeLayoutTemplate)
+		return renameStage{template: &template}
+	case len(renameConfig.Layout) > 0:
+		return renameStage{layout: renameConfig.Layout}
+	case len(renameConfig.Template) > 0:
+		// The template has been validated together with the config
+		template, _ := release.ParseTemplate(renameConfig.Template)
+		return renameStage{template: &template}
+	}
+	return nil
+}
+
+// Name implements Stage.
+func (r renameStage) Name() models.StageName {
+	return models.RenameStage
+}
+
+// Run implements Stage.
+func (r renameStage) Run(ctx context.Context, job *models.Job) error {
+	if r.template != nil {
+		r.renameFromTemplate(job)
+		return nil
+	}
+	switch r.layout {
+	case config.AudiobookshelfLayout:
+		folder := audiobookFor(job).Path()
+		for i, fi := range job.Files {
+			job.Files[i].Target = path.Join(folder, withoutReleaseFolder(fi.Target))
+		}
+	case config.MusicLayout:
+		renameTracks(job)
+	}
+	return nil
+}
+
+// renameTracks sorts tracks into AlbumArtist/Album (Year)/NN - Title using the metadata of the tag stage.
+// Other files like covers or cue sheets are moved into the folder of the album they were released with.
+func renameTracks(job *models.Job) {
+	albumFolder := ""
+	for i, fi := range job.Files {
+		if fi.Metadata == nil || !hasExtension(fi.Target, audioExtensions) {
+			continue
+		}
+		m := fi.Metadata
+		album := m.Album
+		if m.Year > 0 {
+			album = fmt.Sprintf("%s (%d)", album, m.Year)
+		}
+		name := m.Title
+		if m.Track > 0 {
+			name = fmt.Sprintf("%02d - %s", m.Track, name)
+			if m.Disc > 1 {
+				name = fmt.Sprintf("%d-%s", m.Disc, name)
+			}
+		}
+		folder := path.Join(sanitizeFileName(m.AlbumArtist), sanitizeFileName(album))
+		job.Files[i].Target = path.Join(folder, sanitizeFileName(name)+strings.ToLower(path.Ext(fi.Target)))
+		if len(albumFolder) == 0 {
+			albumFolder = folder
+		}
+	}
+	if len(albumFolder) == 0 {
+		return
+	}
+	for i, fi := range job.Files {
+		if fi.Metadata == nil || !hasExtension(fi.Target, audioExtensions) {
+			job.Files[i].Target = path.Join(albumFolder, path.Base(filepath.ToSlash(fi.Target)))
+		}
+	}
+}
+
+// sanitizeFileName removes characters which are not allowed in file names, falling back to "Unknown" for empty names
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if len(name) == 0 {
+		return "Unknown"
+	}
+	return name
+}
+
+// renameFromTemplate renders the target of every file from its name. The title and year are taken from the name
+// of the job as file names of releases are often abbreviated, the remaining values are completed by it,
+// e.g. for episodes of a season pack named "05.mkv".
+func (r renameStage) renameFromTemplate(job *models.Job) {
+	parse := parserFor(job.Category)
+	jobRelease := releaseOfJob(job, parse)
+	originals := make([]string, len(job.Files))
+	for i, fi := range job.Files {
+		ext := path.Ext(fi.Target)
+		originals[i] = path.Base(filepath.ToSlash(fi.Target))
+		original := strings.TrimSuffix(originals[i], ext)
+		name := original
+		if fi.Metadata != nil && len(fi.Metadata.Title) > 0 {
+			name = fi.Metadata.Title
+		}
+		values := release.Values{
+			Release:  parse(name).Merge(jobRelease),
+			Ext:      strings.TrimPrefix(ext, "."),
+			Original: original,
+		}
+		if len(jobRelease.Title) > 0 {
+			values.Title = jobRelease.Title
+		}
+		if jobRelease.Year > 0 {
+			values.Year = jobRelease.Year
+		}
+		target := r.template.Render(values)
+		if len(values.Title) == 0 || len(target) == 0 {
+			log.Printf("Keeping %s of job %s as no title could be parsed from its name", fi.Target, job.Id)
+			continue
+		}
+		job.Files[i].Target = target
+	}
+	disambiguateTargets(job, originals)
+}
+
+// disambiguateTargets keeps files apart which were renamed to the same target, like CD1 and CD2 or extras of a movie.
+// The largest file keeps the target, the others keep their original name in its folder, numbered if that is taken as well.
+func disambiguateTargets(job *models.Job, originals []string) {
+	largest := map[string]int{}
+	for i, fi := range job.Files {
+		if l, ok := largest[fi.Target]; !ok || fi.Size > job.Files[l].Size {
+			largest[fi.Target] = i
+		}
+	}
+	taken := map[string]struct{}{}
+	for target := range largest {
+		taken[target] = struct{}{}
+	}
+	for i, fi := range job.Files {
+		if largest[fi.Target] == i {
+			continue
+		}
+		original := path.Join(path.Dir(fi.Target), originals[i])
+		target := original
+		for n := 2; ; n++ {
+			if _, ok := taken[target]; !ok {
+				break
+			}
+			ext := path.Ext(original)
+			target = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(original, ext), n, ext)
+		}
+		log.Printf("Renaming %s of job %s to %s as %s is the target of a larger file", originals[i], job.Id, target, fi.Target)
+		taken[target] = struct{}{}
+		job.Files[i].Target = target
+	}
+}
+
+// releaseOfJob parses the name of a torrent job. Jobs of yt-dlp are named after their URL and are parsed per file instead.
+func releaseOfJob(job *models.Job, parse func(name string) release.Release) release.Release {
+	if job.Source != models.TorrentJob {
+		return release.Release{}
+	}
+	name := job.Name
+	// Torrents of a single file are named after the file
+	if len(job.Files) == 1 && path.Base(filepath.ToSlash(job.Files[0].Target)) == name {
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+	return parse(name)
+}
+
+// parserFor returns the parser for the release names of a category. Anime releases follow the conventions of fansub groups.
+func parserFor(category models.MediaCategory) func(name string) release.Release {
+	if category == models.Anime {
+		return release.ParseAnime
+	}
+	return release.Parse
+}
+
+// audiobookFor determines the book of a job from the metadata reported by yt-dlp or the name of the release
+func audiobookFor(job *models.Job) release.Audiobook {
+	for _, fi := range job.Files {
+		m := fi.Metadata
+		if m == nil {
+			continue
+		}
+		author := m.Artist
+		if len(author) == 0 {
+			author = m.Channel
+		}
+		// Playlists and albums are books split into chapters
+		if title := m.Album; len(title) > 0 || len(m.Playlist) > 0 {
+			if len(title) == 0 {
+				title = m.Playlist
+			}
+			return release.Audiobook{Author: author, Title: title}
+		}
+		book := release.ParseAudiobook(m.Title)
+		if len(book.Author) == 0 {
+			book.Author = author
+		}
+		return book
+	}
+	return release.ParseAudiobook(job.Name)
+}
+
+// withoutReleaseFolder strips the top level folder of a release as it is replaced by the folder of the layout
+func withoutReleaseFolder(target string) string {
+	_, rest, ok := strings.Cut(filepath.ToSlash(target), "/")
+	if !ok {
+		return target
+	}
+	return rest
+}
@@ -0,0 +1,81 @@
+package postprocess
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+func TestRenameFromTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		job      models.Job
+		want     []string
+	}{
+		{
+			name:     "episodes of a season pack",
+			template: "{Series}/Season {Season:00}/{Series} - S{Season:00}E{Episode:00}.{ext}",
+			job: models.Job{
+				Name:     "Show.S01.1080p.WEB-DL.x264-GRP",
+				Category: models.Series,
+				Files: []models.JobFile{
+					{Target: "Show.S01.1080p.WEB-DL.x264-GRP/Show.S01E01.1080p.WEB-DL.x264-GRP.mkv", Size: 100},
+					{Target: "Show.S01.1080p.WEB-DL.x264-GRP/Show.S01E02.1080p.WEB-DL.x264-GRP.mkv", Size: 100},
+				},
+			},
+			want: []string{"Show/Season 01/Show - S01E01.mkv", "Show/Season 01/Show - S01E02.mkv"},
+		},
+		{
+			name:     "parts and extras of a movie",
+			template: "{Title} ({Year})/{Title} ({Year}).{ext}",
+			job: models.Job{
+				Name:     "Movie.2019.1080p.BluRay.x264-GRP",
+				Category: models.Movies,
+				Files: []models.JobFile{
+					{Target: "Movie/Sample/movie-sample.mkv", Size: 10},
+					{Target: "Movie/movie.cd1.mkv", Size: 100},
+					{Target: "Movie/movie.cd2.mkv", Size: 90},
+					{Target: "Movie/movie.nfo", Size: 1},
+				},
+			},
+			want: []string{"Movie (2019)/movie-sample.mkv", "Movie (2019)/Movie (2019).mkv", "Movie (2019)/movie.cd2.mkv", "Movie (2019)/Movie (2019).nfo"},
+		},
+		{
+			name:     "same original names",
+			template: "{Title} ({Year})/{Title} ({Year}).{ext}",
+			job: models.Job{
+				Name:     "Movie.2019.1080p.BluRay.x264-GRP",
+				Category: models.Movies,
+				Files: []models.JobFile{
+					{Target: "CD1/movie.mkv", Size: 100},
+					{Target: "CD2/movie.mkv", Size: 100},
+					{Target: "CD3/movie.mkv", Size: 100},
+				},
+			},
+			want: []string{"Movie (2019)/Movie (2019).mkv", "Movie (2019)/movie.mkv", "Movie (2019)/movie (2).mkv"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stage := newRenameStage(config.CategoryConfig{Rename: config.RenameConfig{Template: test.template}})
+			job := test.job
+			job.Source = models.TorrentJob
+
+			if err := stage.Run(context.Background(), &job); err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, len(job.Files))
+			for i, fi := range job.Files {
+				got[i] = fi.Target
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("targets = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package postprocess
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+const (
+	jobStoreLimit int = 100
+)
+
+// JobStore keeps snapshots of the most recent jobs
+type JobStore interface {
+	Update(job *models.Job)
+	List() []models.Job
+}
+
+type memoryJobStore struct {
+	mu    *sync.Mutex
+	jobs  map[string]models.Job
+	order []string
+}
+
+func NewJobStore() JobStore {
+	return &memoryJobStore{
+		mu:   &sync.Mutex{},
+		jobs: map[string]models.Job{},
+	}
+}
+
+// Update implements JobStore.
+func (m *memoryJobStore) Update(job *models.Job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.jobs[job.Id]; !ok {
+		m.order = append(m.order, job.Id)
+		if len(m.order) > jobStoreLimit {
+			delete(m.jobs, m.order[0])
+			m.order = m.order[1:]
+		}
+	}
+	m.jobs[job.Id] = job.Clone()
+}
+
+// List implements JobStore. The most recent job comes first.
+func (m *memoryJobStore) List() []models.Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]models.Job, 0, len(m.order))
+	for _, id := range slices.Backward(m.order) {
+		jobs = append(jobs, m.jobs[id])
+	}
+	return jobs
+}
@@ -0,0 +1,277 @@
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/models"
+	"github.com/bongofriend/torrent-ingest/release"
+)
+
+const (
+	// Only the last tokens of a name are searched for a language as titles may contain words like "It"
+	languageTokens int = 3
+)
+
+var (
+	subtitleExtensions = map[string]struct{}{".srt": {}, ".ass": {}, ".ssa": {}, ".vtt": {}, ".sub": {}, ".idx": {}}
+	// Text based subtitles which can be embedded into mkv and mp4 containers
+	embeddableSubtitleExtensions = map[string]struct{}{".srt": {}, ".ass": {}, ".ssa": {}, ".vtt": {}}
+	subtitleTokenPattern         = regexp.MustCompile(`[._\s\-]+`)
+	subtitleFlags                = map[string]string{"forced": "forced", "sdh": "sdh", "hi": "sdh", "cc": "sdh"}
+)
+
+// language is a language with its ISO 639-1 and ISO 639-2 codes
+type language struct {
+	iso1 string
+	iso2 string
+}
+
+// languages maps the codes and english names used in subtitle file names to their language
+var languages = map[string]language{}
+
+func init() {
+	for _, l := range [][]string{
+		{"en", "eng", "english"}, {"de", "ger", "deu", "german", "deutsch"}, {"fr", "fre", "fra", "french"},
+		{"es", "spa", "spanish"}, {"it", "ita", "italian"}, {"pt", "por", "portuguese"}, {"nl", "dut", "nld", "dutch"},
+		{"sv", "swe", "swedish"}, {"no", "nor", "norwegian"}, {"da", "dan", "danish"}, {"fi", "fin", "finnish"},
+		{"pl", "pol", "polish"}, {"ru", "rus", "russian"}, {"ja", "jpn", "japanese"}, {"zh", "chi", "zho", "chinese"},
+		{"ko", "kor", "korean"}, {"ar", "ara", "arabic"}, {"tr", "tur", "turkish"}, {"cs", "cze", "ces", "czech"},
+		{"hu", "hun", "hungarian"}, {"el", "gre", "ell", "greek"}, {"he", "heb", "hebrew"}, {"ro", "rum", "ron", "romanian"},
+		{"uk", "ukr", "ukrainian"}, {"vi", "vie", "vietnamese"}, {"th", "tha", "thai"}, {"id", "ind", "indonesian"},
+	} {
+		for _, name := range l {
+			languages[name] = language{iso1: l[0], iso2: l[1]}
+		}
+	}
+}
+
+// subtitle is an external subtitle assigned to a video of the job
+type subtitle struct {
+	index    int
+	video    int
+	language language
+	flags    []string
+}
+
+// subtitleStage renames external subtitles after the video they belong to and optionally embeds them
+type subtitleStage struct {
+	embed bool
+}
+
+func newSubtitleStage(categoryConfig config.CategoryConfig) Stage {
+	if !categoryConfig.Subtitles.Enabled {
+		return nil
+	}
+	return subtitleStage{embed: categoryConfig.Subtitles.Embed}
+}
+
+// Name implements Stage.
+func (s subtitleStage) Name() models.StageName {
+	return models.SubtitleStage
+}
+
+// Run implements Stage.
+func (s subtitleStage) Run(ctx context.Context, job *models.Job) error {
+	videos := []int{}
+	for i, fi := range job.Files {
+		if hasExtension(fi.Target, videoExtensions) {
+			videos = append(videos, i)
+		}
+	}
+	if len(videos) == 0 {
+		return nil
+	}
+	subtitles := []subtitle{}
+	for i, fi := range job.Files {
+		if !hasExtension(fi.Target, subtitleExtensions) {
+			continue
+		}
+		video, ok := matchVideo(job.Files, videos, fi)
+		if !ok {
+			continue
+		}
+		sub := subtitle{index: i, video: video}
+		sub.language, sub.flags = subtitleLanguage(sourceStem(fi), sourceStem(job.Files[video]))
+		subtitles = append(subtitles, sub)
+	}
+	renameSubtitles(job, subtitles)
+	if s.embed {
+		s.embedSubtitles(ctx, job, subtitles)
+	}
+	return nil
+}
+
+// matchVideo finds the video a subtitle belongs to by the original names of the files, e.g. "Movie.mkv" and "Movie.en.srt",
+// "Subs/Show.S01E02/2_English.srt" or the same episode numbers. Subtitles of a job with a single video belong to it.
+func matchVideo(files []models.JobFile, videos []int, sub models.JobFile) (int, bool) {
+	if len(videos) == 1 {
+		return videos[0], true
+	}
+	subStem := sourceStem(sub)
+	subFolder := path.Base(path.Dir(filepath.ToSlash(sub.Source)))
+	for _, v := range videos {
+		videoStem := sourceStem(files[v])
+		if strings.HasPrefix(subStem, videoStem) || subFolder == videoStem {
+			return v, true
+		}
+	}
+	subRelease := release.Parse(subStem)
+	if !subRelease.HasSeason || len(subRelease.Episodes) == 0 {
+		return 0, false
+	}
+	for _, v := range videos {
+		videoRelease := release.Parse(sourceStem(files[v]))
+		if videoRelease.HasSeason && videoRelease.Season == subRelease.Season && slices.Equal(videoRelease.Episodes, subRelease.Episodes) {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// subtitleLanguage reads the language and flags like "forced" from the name of a subtitle, e.g. "Movie.en.forced" or "2_English"
+func subtitleLanguage(subStem string, videoStem string) (language, []string) {
+	tokens := subtitleTokenPattern.Split(strings.ToLower(subStem), -1)
+	if rest, ok := strings.CutPrefix(subStem, videoStem); ok {
+		tokens = subtitleTokenPattern.Split(strings.ToLower(rest), -1)
+	} else if len(tokens) > languageTokens {
+		tokens = tokens[len(tokens)-languageTokens:]
+	}
+	lang := language{}
+	flags := []string{}
+	for _, t := range tokens {
+		if flag, ok := subtitleFlags[t]; ok && !slices.Contains(flags, flag) {
+			flags = append(flags, flag)
+			continue
+		}
+		if l, ok := languages[t]; ok && len(lang.iso1) == 0 {
+			lang = l
+		}
+	}
+	return lang, flags
+}
+
+// renameSubtitles names subtitles after their video with language and flags like "Movie.en.forced.srt".
+// The sub file of idx/sub pairs keeps the name of its idx file.
+func renameSubtitles(job *models.Job, subtitles []subtitle) {
+	used := map[string]struct{}{}
+	renamed := map[string]string{}
+	for _, sub := range subtitles {
+		fi := job.Files[sub.index]
+		videoTarget := job.Files[sub.video].Target
+		base := strings.TrimSuffix(videoTarget, path.Ext(videoTarget))
+		if len(sub.language.iso1) > 0 {
+			base += "." + sub.language.iso1
+		}
+		for _, f := range sub.flags {
+			base += "." + f
+		}
+		ext := strings.ToLower(path.Ext(fi.Target))
+		if other, ok := renamed[strings.TrimSuffix(fi.Source, filepath.Ext(fi.Source))]; ok && (ext == ".sub" || ext == ".idx") {
+			job.Files[sub.index].Target = other + ext
+			continue
+		}
+		target := base
+		for n := 2; ; n++ {
+			if _, ok := used[target+ext]; !ok {
+				break
+			}
+			target = base + "." + strconv.Itoa(n)
+		}
+		used[target+ext] = struct{}{}
+		renamed[strings.TrimSuffix(fi.Source, filepath.Ext(fi.Source))] = target
+		job.Files[sub.index].Target = target + ext
+	}
+}
+
+// embedSubtitles adds the text based subtitles to mkv and mp4 videos. Subtitles which could not be embedded are imported as external files.
+func (s subtitleStage) embedSubtitles(ctx context.Context, job *models.Job, subtitles []subtitle) {
+	byVideo := map[int][]subtitle{}
+	for _, sub := range subtitles {
+		if hasExtension(job.Files[sub.index].Target, embeddableSubtitleExtensions) {
+			byVideo[sub.video] = append(byVideo[sub.video], sub)
+		}
+	}
+	embedded := map[int]struct{}{}
+	for video, subs := range byVideo {
+		fi := job.Files[video]
+		ext := strings.ToLower(path.Ext(fi.Target))
+		if ext != ".mkv" && ext != ".mp4" {
+			continue
+		}
+		output := filepath.Join(job.WorkDir, "subtitle", strconv.Itoa(video), filepath.Base(fi.Target))
+		if err := embedIntoVideo(ctx, job.Files, fi.Source, output, ext, subs); err != nil {
+			log.Printf("Embedding subtitles into %s failed: %s", fi.Target, err)
+			continue
+		}
+		job.Files[video].Source = output
+		for _, sub := range subs {
+			embedded[sub.index] = struct{}{}
+		}
+	}
+	files := []models.JobFile{}
+	for i, fi := range job.Files {
+		if _, ok := embedded[i]; ok {
+			job.Result.Skipped = append(job.Result.Skipped, models.SkippedFile{Path: fi.Target, Reason: "subtitle was embedded"})
+			continue
+		}
+		files = append(files, fi)
+	}
+	job.Files = files
+}
+
+func embedIntoVideo(ctx context.Context, files []models.JobFile, video string, output string, ext string, subs []subtitle) error {
+	probed, err := probe(ctx, video)
+	if err != nil {
+		return err
+	}
+	existing := 0
+	for _, stream := range probed.Streams {
+		if stream.CodecType == "subtitle" {
+			existing++
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return err
+	}
+	args := []string{"-i", video}
+	for _, sub := range subs {
+		args = append(args, "-i", files[sub.index].Source)
+	}
+	args = append(args, "-map", "0")
+	for i := range subs {
+		args = append(args, "-map", strconv.Itoa(i+1))
+	}
+	args = append(args, "-c", "copy")
+	if ext == ".mp4" {
+		// mp4 only supports text subtitles as mov_text
+		args = append(args, "-c:s", "mov_text")
+	} else {
+		args = append(args, "-c:s", "copy")
+	}
+	for i, sub := range subs {
+		stream := fmt.Sprintf("-metadata:s:s:%d", existing+i)
+		if len(sub.language.iso2) > 0 {
+			args = append(args, stream, "language="+sub.language.iso2)
+		}
+		if slices.Contains(sub.flags, "forced") {
+			args = append(args, fmt.Sprintf("-disposition:s:%d", existing+i), "forced")
+		}
+	}
+	return runFfmpeg(ctx, append(args, output)...)
+}
+
+// sourceStem returns the original name of a file without extension
+func sourceStem(fi models.JobFile) string {
+	name := filepath.Base(fi.Source)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
@@ -0,0 +1,235 @@
+package postprocess
+
+import (
+	"context"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+var (
+	audioExtensions = map[string]struct{}{
+		".mp3": {}, ".flac": {}, ".ogg": {}, ".opus": {}, ".m4a": {}, ".m4b": {}, ".aac": {}, ".wav": {}, ".wma": {}, ".alac": {},
+	}
+	// Matches file names like "03 - Title", "03. Title" or "03 Title"
+	trackFileNamePattern = regexp.MustCompile(`^(?:(\d)-)?(\d{1,3})[\s.\-_]+(.+)$`)
+	// Matches a year in brackets like "(2019)" or "[2019]"
+	bracketYearPattern = regexp.MustCompile(`[\[(]((?:19|20)\d{2})[\])]`)
+	// Matches tags in brackets like "[FLAC]" or "(Deluxe Edition)"
+	folderTagPattern     = regexp.MustCompile(`\s*[\[(][^\])]*[\])]`)
+	leadingNumberPattern = regexp.MustCompile(`^\d+`)
+	spacePattern         = regexp.MustCompile(`\s+`)
+	// Matches decorations of titles on video platforms like "(Official Video)" or "[Lyrics]"
+	uploadDecorationPattern = regexp.MustCompile(`(?i)\s*[\[(](official\s+(music\s+)?(video|audio)|(official\s+)?lyrics?(\s+video)?|audio|visuali[sz]er|hd|hq|4k)[\])]`)
+)
+
+// tagStage reads the tags of audio files into the metadata of the job and writes the tags which are missing or not normalized
+type tagStage struct {
+	write bool
+}
+
+func newTagStage(categoryConfig config.CategoryConfig) Stage {
+	if !categoryConfig.Tag.Enabled {
+		return nil
+	}
+	return tagStage{write: categoryConfig.Tag.Write}
+}
+
+// Name implements Stage.
+func (t tagStage) Name() models.StageName {
+	return models.TagStage
+}
+
+// Run implements Stage.
+func (t tagStage) Run(ctx context.Context, job *models.Job) error {
+	for i, fi := range job.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !hasExtension(fi.Target, audioExtensions) {
+			continue
+		}
+		probed, err := probe(ctx, fi.Source)
+		if err != nil {
+			return err
+		}
+		fromTags := metadataFromTags(probed)
+		metadata := mergeMetadata(fromTags, fi.Metadata, metadataFromPath(fi.Target))
+		job.Files[i].Metadata = &metadata
+		if !t.write {
+			continue
+		}
+		changed := changedTags(fromTags, metadata)
+		if len(changed) == 0 {
+			continue
+		}
+		tagged := filepath.Join(job.WorkDir, "tag", strconv.Itoa(i), filepath.Base(fi.Source))
+		if err := writeTags(ctx, fi.Source, tagged, changed); err != nil {
+			// The file is imported with its original tags
+			log.Printf("Writing tags of %s failed: %s", fi.Target, err)
+			continue
+		}
+		job.Files[i].Source = tagged
+	}
+	return nil
+}
+
+func metadataFromTags(probed probeResult) models.Metadata {
+	return models.Metadata{
+		Title:       probed.tag("title"),
+		Artist:      probed.tag("artist"),
+		AlbumArtist: probed.tag("album_artist", "albumartist", "album artist"),
+		Album:       probed.tag("album"),
+		Genre:       probed.tag("genre"),
+		Year:        leadingNumber(probed.tag("date", "year", "originaldate")),
+		Track:       leadingNumber(probed.tag("track", "tracknumber")),
+		Disc:        leadingNumber(probed.tag("disc", "discnumber")),
+	}
+}
+
+// metadataFromPath reads the metadata of a file from a path like "Artist - Album (2019)/03 - Title.mp3"
+func metadataFromPath(target string) models.Metadata {
+	target = filepath.ToSlash(target)
+	name := strings.TrimSuffix(path.Base(target), path.Ext(target))
+	m := models.Metadata{Title: name}
+	if match := trackFileNamePattern.FindStringSubmatch(name); match != nil {
+		m.Disc, _ = strconv.Atoi(match[1])
+		m.Track, _ = strconv.Atoi(match[2])
+		m.Title = match[3]
+	}
+	if artist, title, ok := strings.Cut(m.Title, " - "); ok {
+		m.Artist, m.Title = artist, title
+	}
+	if dir := path.Dir(target); dir != "." {
+		folder := path.Base(dir)
+		if year := bracketYearPattern.FindStringSubmatch(folder); year != nil {
+			m.Year, _ = strconv.Atoi(year[1])
+		}
+		folder = strings.TrimSpace(folderTagPattern.ReplaceAllString(folder, ""))
+		if artist, album, ok := strings.Cut(folder, " - "); ok {
+			m.AlbumArtist, m.Album = artist, album
+		} else {
+			m.Album = folder
+		}
+	}
+	return m
+}
+
+// mergeMetadata combines the metadata from the given sources, earlier sources take precedence
+func mergeMetadata(tags models.Metadata, source *models.Metadata, fromPath models.Metadata) models.Metadata {
+	m := tags
+	others := []models.Metadata{fromPath}
+	if source != nil {
+		m.Channel, m.Playlist, m.PlaylistIndex = source.Channel, source.Playlist, source.PlaylistIndex
+		m.Description, m.UploadDate, m.Tags = source.Description, source.UploadDate, source.Tags
+		// yt-dlp downloads report the uploader and playlist if the platform does not know about artist and album
+		fallback := *source
+		fallback.Artist = firstNonEmpty(source.Artist, source.Channel)
+		fallback.Album = firstNonEmpty(source.Album, source.Playlist)
+		if fallback.Track == 0 {
+			fallback.Track = source.PlaylistIndex
+		}
+		if fallback.Year == 0 && len(source.UploadDate) >= 4 {
+			fallback.Year, _ = strconv.Atoi(source.UploadDate[:4])
+		}
+		others = []models.Metadata{fallback, fromPath}
+	}
+	for _, o := range others {
+		m.Title = firstNonEmpty(m.Title, o.Title)
+		m.Artist = firstNonEmpty(m.Artist, o.Artist)
+		m.AlbumArtist = firstNonEmpty(m.AlbumArtist, o.AlbumArtist)
+		m.Album = firstNonEmpty(m.Album, o.Album)
+		m.Genre = firstNonEmpty(m.Genre, o.Genre)
+		m.Year = firstNonZero(m.Year, o.Year)
+		m.Track = firstNonZero(m.Track, o.Track)
+		m.Disc = firstNonZero(m.Disc, o.Disc)
+	}
+	m.Title = normalizeTagValue(uploadDecorationPattern.ReplaceAllString(m.Title, ""))
+	m.Artist = normalizeTagValue(m.Artist)
+	// Uploads are often titled "Artist - Title"
+	if title, ok := strings.CutPrefix(m.Title, m.Artist+" - "); ok && len(m.Artist) > 0 {
+		m.Title = title
+	}
+	m.AlbumArtist = normalizeTagValue(firstNonEmpty(m.AlbumArtist, m.Artist))
+	m.Artist = firstNonEmpty(m.Artist, m.AlbumArtist)
+	// Singles are filed as an album of their own
+	m.Album = normalizeTagValue(firstNonEmpty(m.Album, m.Title))
+	return m
+}
+
+// changedTags returns the tags to write for the values which are missing in the file or have been normalized
+func changedTags(fromTags models.Metadata, m models.Metadata) map[string]string {
+	changed := map[string]string{}
+	add := func(name string, current string, value string) {
+		if current != value && len(value) > 0 {
+			changed[name] = value
+		}
+	}
+	add("title", fromTags.Title, m.Title)
+	add("artist", fromTags.Artist, m.Artist)
+	add("album_artist", fromTags.AlbumArtist, m.AlbumArtist)
+	add("album", fromTags.Album, m.Album)
+	add("genre", fromTags.Genre, m.Genre)
+	// Numbers are only written if missing as they are often stored with additional information like "03/12"
+	if fromTags.Year == 0 && m.Year > 0 {
+		changed["date"] = strconv.Itoa(m.Year)
+	}
+	if fromTags.Track == 0 && m.Track > 0 {
+		changed["track"] = strconv.Itoa(m.Track)
+	}
+	if fromTags.Disc == 0 && m.Disc > 0 {
+		changed["disc"] = strconv.Itoa(m.Disc)
+	}
+	return changed
+}
+
+// writeTags copies the streams of a file to target while adding the given tags to the existing ones
+func writeTags(ctx context.Context, source string, target string, tags map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	args := []string{"-i", source, "-map", "0", "-c", "copy", "-map_metadata", "0"}
+	for name, value := range tags {
+		args = append(args, "-metadata", name+"="+value)
+	}
+	if strings.EqualFold(filepath.Ext(source), ".mp3") {
+		// ID3v2.3 is supported by more players than the default of ffmpeg
+		args = append(args, "-id3v2_version", "3")
+	}
+	return runFfmpeg(ctx, append(args, target)...)
+}
+
+// leadingNumber reads numbers like "3", "03/12" or "2019-05-01"
+func leadingNumber(value string) int {
+	n, _ := strconv.Atoi(leadingNumberPattern.FindString(value))
+	return n
+}
+
+func normalizeTagValue(value string) string {
+	return strings.TrimSpace(spacePattern.ReplaceAllString(value, " "))
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if len(strings.TrimSpace(v)) > 0 {
+			return v
+		}
+	}
+	return ""
+}
+
+func firstNonZero(values ...int) int {
+	for _, v := range values {
+		if v != 0 {
+			return v
+		}
+	}
+	return 0
+}
@@ -0,0 +1,211 @@
+package postprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+const (
+	defaultTranscodeContainer string = "mp4"
+	defaultTranscodeCrf       int    = 23
+	defaultTranscodePreset    string = "medium"
+)
+
+var (
+	// transcodeWorkers bounds the number of ffmpeg processes across all jobs as each of them uses several cores
+	transcodeWorkers = make(chan struct{}, max(1, runtime.NumCPU()/4))
+	// Subtitle codecs which can be stored in mp4 containers after converting them to mov_text
+	textSubtitleCodecs = []string{"subrip", "ass", "ssa", "webvtt", "mov_text", "text"}
+)
+
+// transcodeStage remuxes or transcodes videos into a format every player supports
+type transcodeStage struct {
+	config config.TranscodeConfig
+}
+
+func newTranscodeStage(categoryConfig config.CategoryConfig) Stage {
+	transcodeConfig := categoryConfig.Transcode
+	if len(transcodeConfig.Mode) == 0 {
+		return nil
+	}
+	if len(transcodeConfig.Container) == 0 {
+		transcodeConfig.Container = defaultTranscodeContainer
+	}
+	if transcodeConfig.Crf == 0 {
+		transcodeConfig.Crf = defaultTranscodeCrf
+	}
+	if len(transcodeConfig.Preset) == 0 {
+		transcodeConfig.Preset = defaultTranscodePreset
+	}
+	extensions := make([]string, len(transcodeConfig.Extensions))
+	for i, e := range transcodeConfig.Extensions {
+		extensions[i] = strings.TrimPrefix(strings.ToLower(e), ".")
+	}
+	transcodeConfig.Extensions = extensions
+	return transcodeStage{config: transcodeConfig}
+}
+
+// Name implements Stage.
+func (t transcodeStage) Name() models.StageName {
+	return models.TranscodeStage
+}
+
+// Run implements Stage.
+func (t transcodeStage) Run(ctx context.Context, job *models.Job) error {
+	return t.runWithProgress(ctx, job, func() {})
+}
+
+// runWithProgress implements progressStage.
+func (t transcodeStage) runWithProgress(ctx context.Context, job *models.Job, report func()) error {
+	type transcoded struct {
+		index  int
+		output string
+		err    error
+	}
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	results := []*transcoded{}
+	for i, fi := range job.Files {
+		if hasExtension(fi.Target, videoExtensions) {
+			results = append(results, &transcoded{index: i})
+			job.Progress = append(job.Progress, models.FileProgress{File: fi.Target})
+		}
+	}
+	for progressIndex, result := range results {
+		fi := job.Files[result.index]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case transcodeWorkers <- struct{}{}:
+				defer func() { <-transcodeWorkers }()
+			case <-ctx.Done():
+				result.err = ctx.Err()
+				return
+			}
+			output := filepath.Join(job.WorkDir, "transcode", strconv.Itoa(result.index), strings.TrimSuffix(filepath.Base(fi.Target), filepath.Ext(fi.Target))+"."+t.config.Container)
+			result.output, result.err = t.transcode(ctx, fi, output, func(percent float64) {
+				mu.Lock()
+				defer mu.Unlock()
+				// Only whole percents are reported to not flood the job store
+				if math.Floor(percent) > job.Progress[progressIndex].Percent {
+					job.Progress[progressIndex].Percent = math.Floor(percent)
+					report()
+				}
+			})
+		}()
+	}
+	mu.Lock()
+	report()
+	mu.Unlock()
+	wg.Wait()
+
+	errs := []error{}
+	kept := []models.JobFile{}
+	for _, r := range results {
+		fi := job.Files[r.index]
+		if r.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", fi.Target, r.err))
+			continue
+		}
+		if len(r.output) == 0 {
+			continue
+		}
+		target := t.outputTarget(fi.Target)
+		if t.config.KeepOriginal {
+			kept = append(kept, models.JobFile{Source: r.output, Target: target, Metadata: fi.Metadata})
+			continue
+		}
+		job.Result.Skipped = append(job.Result.Skipped, models.SkippedFile{Path: fi.Target, Reason: "replaced by " + target})
+		job.Files[r.index] = models.JobFile{Source: r.output, Target: target, Metadata: fi.Metadata}
+	}
+	job.Files = append(job.Files, kept...)
+	return errors.Join(errs...)
+}
+
+// outputTarget returns the target of a converted video. When a kept original already has the extension of the container,
+// ".h264" or ".remux" is added to the name of the converted video to not overwrite the original in the transfer.
+func (t transcodeStage) outputTarget(target string) string {
+	stem := strings.TrimSuffix(target, path.Ext(target))
+	if !t.config.KeepOriginal || !strings.EqualFold(path.Ext(target), "."+t.config.Container) {
+		return stem + "." + t.config.Container
+	}
+	if t.config.Mode == config.TranscodeMode {
+		return stem + ".h264." + t.config.Container
+	}
+	return stem + ".remux." + t.config.Container
+}
+
+// transcode converts a single video if it matches the configuration. The output is empty if the video is kept as it is.
+func (t transcodeStage) transcode(ctx context.Context, fi models.JobFile, output string, progress func(percent float64)) (string, error) {
+	probed, err := probe(ctx, fi.Source)
+	if err != nil {
+		return "", err
+	}
+	if !t.matches(fi, probed) {
+		progress(100)
+		return "", nil
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return "", err
+	}
+	args := []string{"-i", fi.Source, "-map", "0:V", "-map", "0:a?"}
+	mp4 := t.config.Container == "mp4"
+	subtitles := 0
+	for _, s := range probed.Streams {
+		if s.CodecType != "subtitle" || (mp4 && !slices.Contains(textSubtitleCodecs, s.CodecName)) {
+			continue
+		}
+		args = append(args, "-map", "0:"+strconv.Itoa(s.Index))
+		subtitles++
+	}
+	if t.config.Mode == config.TranscodeMode {
+		args = append(args, "-c:v", "libx264", "-preset", t.config.Preset, "-crf", strconv.Itoa(t.config.Crf), "-pix_fmt", "yuv420p", "-c:a", "aac", "-b:a", "192k")
+	} else {
+		args = append(args, "-c:v", "copy", "-c:a", "copy")
+	}
+	if subtitles > 0 {
+		if mp4 {
+			args = append(args, "-c:s", "mov_text")
+		} else {
+			args = append(args, "-c:s", "copy")
+		}
+	}
+	if mp4 {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return output, runFfmpegWithProgress(ctx, probed.duration(), progress, append(args, output)...)
+}
+
+// matches decides whether a video is converted. Without configured extensions or codecs all videos are converted
+// which are not in the target container or, when transcoding, not encoded as H.264.
+func (t transcodeStage) matches(fi models.JobFile, probed probeResult) bool {
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(fi.Target)), ".")
+	codec := ""
+	for _, s := range probed.Streams {
+		if s.CodecType == "video" && s.Disposition.AttachedPic == 0 {
+			codec = s.CodecName
+			break
+		}
+	}
+	if len(t.config.Extensions) > 0 || len(t.config.VideoCodecs) > 0 {
+		return slices.Contains(t.config.Extensions, ext) || slices.Contains(t.config.VideoCodecs, codec)
+	}
+	if t.config.Mode == config.TranscodeMode {
+		return ext != t.config.Container || codec != "h264"
+	}
+	return ext != t.config.Container
+}
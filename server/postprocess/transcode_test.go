@@ -0,0 +1,29 @@
+package postprocess
+
+import (
+	"testing"
+
+	"github.com/bongofriend/torrent-ingest/config"
+)
+
+func TestTranscodeOutputTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		config config.TranscodeConfig
+		target string
+		want   string
+	}{
+		{"replaced", config.TranscodeConfig{Mode: config.TranscodeMode, Container: "mp4"}, "Movie/Movie.mp4", "Movie/Movie.mp4"},
+		{"other container", config.TranscodeConfig{Mode: config.TranscodeMode, Container: "mp4", KeepOriginal: true}, "Movie/Movie.mkv", "Movie/Movie.mp4"},
+		{"same container", config.TranscodeConfig{Mode: config.TranscodeMode, Container: "mp4", KeepOriginal: true}, "Movie/Movie.mp4", "Movie/Movie.h264.mp4"},
+		{"same container ignores case", config.TranscodeConfig{Mode: config.TranscodeMode, Container: "mp4", KeepOriginal: true}, "Movie/Movie.MP4", "Movie/Movie.h264.mp4"},
+		{"remuxed", config.TranscodeConfig{Mode: config.RemuxMode, Container: "mkv", KeepOriginal: true}, "Movie/Movie.mkv", "Movie/Movie.remux.mkv"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := (transcodeStage{config: test.config}).outputTarget(test.target); got != test.want {
+				t.Errorf("outputTarget(%q) = %q, want %q", test.target, got, test.want)
+			}
+		})
+	}
+}
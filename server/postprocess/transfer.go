@@ -0,0 +1,395 @@
+package postprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/diskspace"
+	"github.com/bongofriend/torrent-ingest/models"
+	cp "github.com/otiai10/copy"
+)
+
+// stagingDirName is the hidden folder in a destination the files of a job are copied into before they are moved into place
+const stagingDirName string = ".torrent-ingest-staging"
+
+// permissions are applied to the imported files, a uid or gid of -1 and a mode of 0 keep the defaults
+type permissions struct {
+	uid      int
+	gid      int
+	fileMode os.FileMode
+	dirMode  os.FileMode
+}
+
+type transferStage struct {
+	conflict    config.ConflictPolicy
+	hash        config.HashAlgorithm
+	permissions permissions
+}
+
+func newTransferStage(categoryConfig config.CategoryConfig) Stage {
+	permissionsConfig := categoryConfig.Permissions
+	// The owner and group have already been resolved when validating the config
+	uid, err := permissionsConfig.Uid()
+	if err != nil {
+		log.Printf("Resolving owner %s failed: %s", permissionsConfig.Owner, err)
+		uid = -1
+	}
+	gid, err := permissionsConfig.Gid()
+	if err != nil {
+		log.Printf("Resolving group %s failed: %s", permissionsConfig.Group, err)
+		gid = -1
+	}
+	conflict := categoryConfig.Conflict
+	if len(conflict) == 0 {
+		conflict = config.OverwriteConflict
+	}
+	return transferStage{conflict: conflict, hash: categoryConfig.Verify.Hash, permissions: permissions{
+		uid:      uid,
+		gid:      gid,
+		fileMode: os.FileMode(permissionsConfig.FileMode),
+		dirMode:  os.FileMode(permissionsConfig.DirMode),
+	}}
+}
+
+// Name implements Stage.
+func (t transferStage) Name() models.StageName {
+	return models.TransferStage
+}
+
+// Run implements Stage. The files are copied into a staging folder on the destination first and moved into place
+// once all of them are complete, so media servers never scan a partially copied file.
+func (t transferStage) Run(ctx context.Context, job *models.Job) error {
+	if err := os.MkdirAll(job.Destination, 0755); err != nil {
+		return err
+	}
+	// Targets are checked again as stages like rename build them from metadata
+	for i, fi := range job.Files {
+		target, err := safeTarget(fi.Target)
+		if err != nil {
+			return err
+		}
+		if err := checkInside(job.Destination, filepath.Dir(filepath.Join(job.Destination, target))); err != nil {
+			return err
+		}
+		job.Files[i].Target = target
+	}
+	files, err := t.resolveConflicts(job)
+	if err != nil {
+		return err
+	}
+	if err := checkSpace(job.Destination, files); err != nil {
+		return err
+	}
+	staging := filepath.Join(job.Destination, stagingDirName, job.Id)
+	defer os.RemoveAll(staging)
+	for _, fi := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		staged := filepath.Join(staging, fi.Target)
+		if err := cp.Copy(fi.Source, staged); err != nil {
+			return err
+		}
+		if err := verifyCopy(fi.Source, staged, t.hash); err != nil {
+			return err
+		}
+		if err := t.permissions.applyToFile(staged); err != nil {
+			return fmt.Errorf("setting permissions of %s: %w", fi.Target, err)
+		}
+	}
+	// Overwritten files are kept until all files are in place to restore them if the import fails
+	replaced := filepath.Join(job.Destination, stagingDirName, job.Id+".replaced")
+	imported := len(job.Result.Imported)
+	undo := []func() error{}
+	for _, fi := range files {
+		steps, err := t.moveIntoPlace(job.Destination, staging, replaced, fi.Target)
+		undo = append(undo, steps...)
+		if err != nil {
+			job.Result.Imported = job.Result.Imported[:imported]
+			if undoErr := rollback(undo); undoErr != nil {
+				return fmt.Errorf("%w, rolling back the import failed, overwritten files are kept in %s: %w", err, replaced, undoErr)
+			}
+			os.RemoveAll(replaced)
+			return err
+		}
+		job.Result.Imported = append(job.Result.Imported, fi.Target)
+	}
+	os.RemoveAll(replaced)
+	return nil
+}
+
+// moveIntoPlace moves a staged file to its target. The returned steps undo the changes made to the destination, also
+// if moving the file failed.
+func (t transferStage) moveIntoPlace(destination string, staging string, replaced string, target string) ([]func() error, error) {
+	undo := []func() error{}
+	placed := filepath.Join(destination, target)
+	created, err := mkdirs(destination, filepath.Dir(placed))
+	for _, dir := range created {
+		undo = append(undo, func() error { return os.Remove(dir) })
+	}
+	if err != nil {
+		return undo, err
+	}
+	if err := t.permissions.applyToDirs(created); err != nil {
+		return undo, fmt.Errorf("setting permissions of %s: %w", filepath.Dir(target), err)
+	}
+	if info, err := os.Lstat(placed); err == nil && !info.IsDir() {
+		kept := filepath.Join(replaced, target)
+		if err := os.MkdirAll(filepath.Dir(kept), 0755); err != nil {
+			return undo, err
+		}
+		if err := os.Rename(placed, kept); err != nil {
+			return undo, err
+		}
+		undo = append(undo, func() error { return os.Rename(kept, placed) })
+	}
+	if err := os.Rename(filepath.Join(staging, target), placed); err != nil {
+		return undo, err
+	}
+	return append(undo, func() error { return os.Remove(placed) }), nil
+}
+
+// rollback undoes the steps of an import which failed partway in reverse order, so the library is left as it was
+func rollback(undo []func() error) error {
+	errs := []error{}
+	for i := len(undo) - 1; i >= 0; i-- {
+		if err := undo[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// resolveConflicts applies the conflict policy to the files which already exist in the destination, or which have the same
+// target as another file of the job, and returns the files to import
+func (t transferStage) resolveConflicts(job *models.Job) ([]models.JobFile, error) {
+	files := []models.JobFile{}
+	conflicts := []models.ConflictFile{}
+	// taken maps the targets of the job to the index of their file in files, -1 if the file is not imported
+	taken := map[string]int{}
+	for _, fi := range job.Files {
+		index, inJob := taken[fi.Target]
+		inJob = inJob && index >= 0
+		var existing fs.FileInfo
+		var err error
+		if inJob {
+			existing, err = os.Stat(files[index].Source)
+		} else {
+			existing, err = os.Stat(filepath.Join(job.Destination, fi.Target))
+			if errors.Is(err, fs.ErrNotExist) {
+				taken[fi.Target] = len(files)
+				files = append(files, fi)
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		conflict := models.ConflictFile{Path: fi.Target}
+		policy := t.conflict
+		if policy == config.KeepLargerConflict {
+			policy = config.SkipConflict
+			if source, err := os.Stat(fi.Source); err == nil && !existing.IsDir() && source.Size() > existing.Size() {
+				policy = config.OverwriteConflict
+			}
+		}
+		reason := "already exists in destination"
+		if inJob {
+			reason = "another file of the job has the same target"
+		}
+		switch policy {
+		case config.SkipConflict:
+			conflict.Resolution = "kept existing file"
+			job.Result.Skipped = append(job.Result.Skipped, models.SkippedFile{Path: fi.Target, Reason: reason})
+			if !inJob {
+				taken[fi.Target] = -1
+			}
+		case config.OverwriteConflict:
+			conflict.Resolution = "overwritten"
+			if inJob {
+				// The file replaces the one of the job it would overwrite in the destination
+				job.Result.Skipped = append(job.Result.Skipped, models.SkippedFile{Path: files[index].Target, Reason: "overwritten by another file of the job"})
+				files[index] = fi
+			} else {
+				taken[fi.Target] = len(files)
+				files = append(files, fi)
+			}
+		case config.RenameConflict:
+			fi.Target = freeTarget(job.Destination, fi.Target, taken)
+			conflict.Resolution = "renamed to " + fi.Target
+			taken[fi.Target] = len(files)
+			files = append(files, fi)
+		default:
+			conflict.Resolution = "failed"
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	job.Result.Conflicts = append(job.Result.Conflicts, conflicts...)
+	if t.conflict == config.FailConflict && len(conflicts) > 0 {
+		paths := make([]string, len(conflicts))
+		for i, c := range conflicts {
+			paths[i] = c.Path
+		}
+		return nil, fmt.Errorf("files already exist in destination or share their target: %s", strings.Join(paths, ", "))
+	}
+	return files, nil
+}
+
+// mkdirs creates dir and its missing parents below the destination and returns the created folders, outermost first
+func mkdirs(destination string, dir string) ([]string, error) {
+	missing := []string{}
+	for ; isBelow(destination, dir); dir = filepath.Dir(dir) {
+		_, err := os.Lstat(dir)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		missing = append(missing, dir)
+	}
+	created := []string{}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], 0755); err != nil {
+			// The folder may have been created by another import meanwhile
+			if errors.Is(err, fs.ErrExist) {
+				continue
+			}
+			return created, err
+		}
+		created = append(created, missing[i])
+	}
+	return created, nil
+}
+
+// freeTarget returns the first target like "Movie (2).mkv" which neither exists in the destination nor is taken by another file of the job
+func freeTarget(destination string, target string, taken map[string]int) string {
+	ext := path.Ext(target)
+	base := strings.TrimSuffix(target, ext)
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if _, ok := taken[candidate]; ok {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(destination, candidate)); err != nil {
+			return candidate
+		}
+	}
+}
+
+// checkSpace makes sure the files fit into the destination before copying them, stages like transcode
+// may have grown the job since the space was checked by the importer
+func checkSpace(destination string, files []models.JobFile) error {
+	size := int64(0)
+	for _, fi := range files {
+		info, err := os.Stat(fi.Source)
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+	}
+	free, err := diskspace.Free(destination)
+	if err != nil {
+		return err
+	}
+	if free < size {
+		return fmt.Errorf("%w: %s has %s free, %s are needed", diskspace.ErrLowSpace, destination, config.ByteSize(free), config.ByteSize(size))
+	}
+	return nil
+}
+
+// verifyCopy checks that a file has been copied completely by its size and, if an algorithm is configured, its checksum
+func verifyCopy(source string, copied string, algorithm config.HashAlgorithm) error {
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	copiedInfo, err := os.Stat(copied)
+	if err != nil {
+		return err
+	}
+	if sourceInfo.Size() != copiedInfo.Size() {
+		return fmt.Errorf("copy of %s is incomplete: %d of %d bytes", filepath.Base(source), copiedInfo.Size(), sourceInfo.Size())
+	}
+	if len(algorithm) == 0 {
+		return nil
+	}
+	sourceHash, err := hashFile(algorithm, source)
+	if err != nil {
+		return err
+	}
+	copiedHash, err := hashFile(algorithm, copied)
+	if err != nil {
+		return err
+	}
+	if sourceHash != copiedHash {
+		return fmt.Errorf("copy of %s is corrupt: %s is %s instead of %s", filepath.Base(source), algorithm, copiedHash, sourceHash)
+	}
+	return nil
+}
+
+// CleanStagingDirs removes the staging folders left behind in the destinations by imports which were interrupted
+func CleanStagingDirs(pathConfig config.PathConfig) {
+	for _, d := range pathConfig.AllDestinations() {
+		staging := filepath.Join(d, stagingDirName)
+		entries, err := os.ReadDir(staging)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Reading staging folder %s failed: %s", staging, err)
+			}
+			continue
+		}
+		for _, e := range entries {
+			log.Printf("Removing stale staging folder %s", filepath.Join(staging, e.Name()))
+		}
+		if err := os.RemoveAll(staging); err != nil {
+			log.Printf("Removing staging folder %s failed: %s", staging, err)
+		}
+	}
+}
+
+// applyToFile sets the ownership and mode of an imported file
+func (p permissions) applyToFile(file string) error {
+	if p.fileMode != 0 {
+		if err := os.Chmod(file, p.fileMode); err != nil {
+			return err
+		}
+	}
+	return p.chown(file)
+}
+
+// applyToDirs sets the ownership and mode of the folders created for the imported files
+func (p permissions) applyToDirs(dirs []string) error {
+	for _, dir := range dirs {
+		if p.dirMode != 0 {
+			if err := os.Chmod(dir, p.dirMode); err != nil {
+				return err
+			}
+		}
+		if err := p.chown(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p permissions) chown(file string) error {
+	if p.uid < 0 && p.gid < 0 {
+		return nil
+	}
+	return os.Lchown(file, p.uid, p.gid)
+}
+
+// isBelow reports whether path is inside of root without being root itself
+func isBelow(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
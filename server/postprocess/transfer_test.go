@@ -0,0 +1,154 @@
+package postprocess
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+func TestResolveConflicts(t *testing.T) {
+	// small.mkv and large.mkv share their target, existing.mkv exists in the destination with 15 bytes
+	tests := []struct {
+		policy  config.ConflictPolicy
+		want    []string
+		skipped int
+		fails   bool
+	}{
+		{config.SkipConflict, []string{"small.mkv -> Movie.mkv"}, 2, false},
+		{config.OverwriteConflict, []string{"large.mkv -> Movie.mkv", "existing.mkv -> Existing.mkv"}, 1, false},
+		{config.RenameConflict, []string{"small.mkv -> Movie.mkv", "large.mkv -> Movie (2).mkv", "existing.mkv -> Existing (2).mkv"}, 0, false},
+		{config.KeepLargerConflict, []string{"large.mkv -> Movie.mkv", "existing.mkv -> Existing.mkv"}, 1, false},
+		{config.FailConflict, nil, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(string(test.policy), func(t *testing.T) {
+			source := t.TempDir()
+			destination := t.TempDir()
+			writeFile(t, filepath.Join(source, "small.mkv"), 10)
+			writeFile(t, filepath.Join(source, "large.mkv"), 20)
+			writeFile(t, filepath.Join(source, "existing.mkv"), 20)
+			writeFile(t, filepath.Join(destination, "Existing.mkv"), 15)
+			job := &models.Job{
+				Destination: destination,
+				Files: []models.JobFile{
+					{Source: filepath.Join(source, "small.mkv"), Target: "Movie.mkv"},
+					{Source: filepath.Join(source, "large.mkv"), Target: "Movie.mkv"},
+					{Source: filepath.Join(source, "existing.mkv"), Target: "Existing.mkv"},
+				},
+			}
+
+			files, err := transferStage{conflict: test.policy}.resolveConflicts(job)
+
+			if test.fails {
+				if err == nil {
+					t.Fatal("resolveConflicts() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, len(files))
+			for i, fi := range files {
+				got[i] = filepath.Base(fi.Source) + " -> " + fi.Target
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("files = %q, want %q", got, test.want)
+			}
+			if len(job.Result.Skipped) != test.skipped {
+				t.Errorf("skipped = %+v, want %d file(s)", job.Result.Skipped, test.skipped)
+			}
+			if len(job.Result.Conflicts) != 2 {
+				t.Errorf("conflicts = %+v, want 2", job.Result.Conflicts)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, name string, size int) {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(strings.Repeat("x", size)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransferSetsPermissionsOfCreatedFolders(t *testing.T) {
+	source := t.TempDir()
+	destination := t.TempDir()
+	writeFile(t, filepath.Join(source, "movie.mkv"), 10)
+	library := filepath.Join(destination, "Library")
+	if err := os.Mkdir(library, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	job := &models.Job{
+		Id:          "job",
+		Destination: destination,
+		Files:       []models.JobFile{{Source: filepath.Join(source, "movie.mkv"), Target: "Library/Movie (2019)/Extras/Movie.mkv"}},
+	}
+	stage := transferStage{conflict: config.OverwriteConflict, permissions: permissions{uid: -1, gid: -1, dirMode: 0o750}}
+
+	if err := stage.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+
+	for dir, want := range map[string]os.FileMode{
+		"Library":                     0o755,
+		"Library/Movie (2019)":        0o750,
+		"Library/Movie (2019)/Extras": 0o750,
+	} {
+		info, err := os.Stat(filepath.Join(destination, dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("mode of %s = %s, want %s", dir, info.Mode().Perm(), want)
+		}
+	}
+}
+
+func TestTransferRollsBackPartialImport(t *testing.T) {
+	source := t.TempDir()
+	destination := t.TempDir()
+	for _, name := range []string{"existing.mkv", "new.mkv", "taken.mkv"} {
+		writeFile(t, filepath.Join(source, name), 20)
+	}
+	writeFile(t, filepath.Join(destination, "Existing.mkv"), 15)
+	// A folder with the target of the last file can not be replaced
+	writeFile(t, filepath.Join(destination, "Taken", "file.mkv"), 5)
+	job := &models.Job{
+		Id:          "job",
+		Destination: destination,
+		Files: []models.JobFile{
+			{Source: filepath.Join(source, "existing.mkv"), Target: "Existing.mkv"},
+			{Source: filepath.Join(source, "new.mkv"), Target: "Folder/New.mkv"},
+			{Source: filepath.Join(source, "taken.mkv"), Target: "Taken"},
+		},
+	}
+	stage := transferStage{conflict: config.OverwriteConflict, permissions: permissions{uid: -1, gid: -1}}
+
+	if err := stage.Run(context.Background(), job); err == nil {
+		t.Fatal("Run() succeeded, want an error")
+	}
+
+	if info, err := os.Stat(filepath.Join(destination, "Existing.mkv")); err != nil || info.Size() != 15 {
+		t.Errorf("overwritten file was not restored: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destination, "Folder")); !os.IsNotExist(err) {
+		t.Errorf("created folder was not removed: %v", err)
+	}
+	if len(job.Result.Imported) != 0 {
+		t.Errorf("imported = %q, want none", job.Result.Imported)
+	}
+	entries, _ := os.ReadDir(filepath.Join(destination, stagingDirName))
+	if len(entries) != 0 {
+		t.Errorf("staging folder not cleaned up: %v", entries)
+	}
+}
@@ -0,0 +1,98 @@
+package postprocess
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/models"
+	"github.com/bongofriend/torrent-ingest/release"
+	"github.com/cespare/xxhash/v2"
+)
+
+// verifyStage checks the downloaded files for corruption before they are imported
+type verifyStage struct {
+	crc32 bool
+}
+
+func newVerifyStage(categoryConfig config.CategoryConfig) Stage {
+	if !categoryConfig.Verify.Crc32 {
+		return nil
+	}
+	return verifyStage{crc32: categoryConfig.Verify.Crc32}
+}
+
+// Name implements Stage.
+func (v verifyStage) Name() models.StageName {
+	return models.VerifyStage
+}
+
+// Run implements Stage.
+func (v verifyStage) Run(ctx context.Context, job *models.Job) error {
+	errs := []error{}
+	for _, fi := range job.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if v.crc32 {
+			if err := verifyCrc32(fi); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", fi.Target, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// verifyCrc32 compares the checksum of a file with the one tagged in its name. Files without a tagged checksum are skipped.
+func verifyCrc32(fi models.JobFile) error {
+	name := path.Base(filepath.ToSlash(fi.Target))
+	expected := release.ParseAnime(strings.TrimSuffix(name, path.Ext(name))).Crc32
+	if len(expected) == 0 {
+		return nil
+	}
+	f, err := os.Open(fi.Source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	if actual := fmt.Sprintf("%08X", hash.Sum32()); actual != expected {
+		return fmt.Errorf("CRC32 is %s instead of %s", actual, expected)
+	}
+	return nil
+}
+
+// hashFile returns the checksum of a file as hex string
+func hashFile(algorithm config.HashAlgorithm, file string) (string, error) {
+	var h hash.Hash
+	switch algorithm {
+	case config.XxhashAlgorithm:
+		h = xxhash.New()
+	case config.Sha256Algorithm:
+		h = sha256.New()
+	default:
+		return "", fmt.Errorf("unknown hash algorithm %s", algorithm)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
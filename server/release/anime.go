@@ -0,0 +1,113 @@
+package release
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	bracketTagPattern = regexp.MustCompile(`[\[(]([^\])]*)[\])]`)
+	// Matches the episode after the title like "Title - 07", "Title - 07v2" or batches like "Title - 01-12" and "Title - 01 ~ 12"
+	animeEpisodePattern = regexp.MustCompile(`(?i)\s-\s(\d{1,4})(?:\s*[-~]\s*(\d{1,4}))?(?:v(\d))?(?:\s|$)`)
+	animeRangePattern   = regexp.MustCompile(`^(\d{1,4})\s*[-~]\s*(\d{1,4})$`)
+	// Matches checksums like "ABCD1234"
+	crc32Pattern      = regexp.MustCompile(`^[0-9A-Fa-f]{8}$`)
+	dimensionsPattern = regexp.MustCompile(`(?i)^\d{3,4}x(\d{3,4})$`)
+	// Matches a season following the title like "Title S2" or "Title Season 2"
+	animeSeasonPattern = regexp.MustCompile(`(?i)\s+(?:S|Season\s*)(\d{1,2})$`)
+	// Words marking batches which are not part of the title
+	batchWordsPattern = regexp.MustCompile(`(?i)\s+(batch|complete)$`)
+)
+
+// ParseAnime extracts the information of fansub releases like "[Group] Title - 07v2 [1080p][ABCD1234]"
+// or batches like "[Group] Title (01-12) [1080p] [Batch]". The name is expected to not include a file extension.
+func ParseAnime(name string) Release {
+	r := Release{}
+	if m := fansubGroupPattern.FindStringSubmatch(name); m != nil {
+		r.Group = strings.TrimSpace(m[1])
+		name = name[len(m[0]):]
+	}
+	for _, m := range bracketTagPattern.FindAllStringSubmatch(name, -1) {
+		for _, tag := range strings.FieldsFunc(m[1], func(r rune) bool { return r == ' ' || r == ',' }) {
+			r.applyAnimeTag(tag)
+		}
+		if rm := animeRangePattern.FindStringSubmatch(strings.TrimSpace(m[1])); rm != nil {
+			r.Absolute = episodeRange(atoi(rm[1]), atoi(rm[2]))
+		}
+	}
+	name = bracketTagPattern.ReplaceAllString(name, " ")
+	if !strings.Contains(strings.TrimSpace(name), " ") {
+		name = strings.NewReplacer(".", " ", "_", " ").Replace(name)
+	}
+	name = strings.TrimSpace(spaces.ReplaceAllString(strings.ReplaceAll(name, "_", " "), " "))
+
+	// Titles may contain " - " themselves, so the last episode number is used
+	title := name
+	if all := animeEpisodePattern.FindAllStringSubmatchIndex(name, -1); len(all) > 0 {
+		m := all[len(all)-1]
+		first := atoi(name[m[2]:m[3]])
+		last := first
+		if m[4] >= 0 {
+			last = atoi(name[m[4]:m[5]])
+		}
+		r.Absolute = episodeRange(first, last)
+		if m[6] >= 0 {
+			r.Version = atoi(name[m[6]:m[7]])
+		}
+		title = name[:m[0]]
+	} else if scene := Parse(name); scene.HasSeason || len(scene.Absolute) > 0 {
+		// Releases using the scene naming like "[Group] Title S02E07"
+		scene.Group, scene.Resolution, scene.Crc32 = r.Group, firstOf(r.Resolution, scene.Resolution), r.Crc32
+		return scene
+	}
+	title = strings.Trim(batchWordsPattern.ReplaceAllString(title, ""), " -")
+	if m := animeSeasonPattern.FindStringSubmatchIndex(title); m != nil {
+		r.Season, r.HasSeason = atoi(title[m[2]:m[3]]), true
+		title = title[:m[0]]
+	}
+	r.Title = title
+	return r
+}
+
+// applyAnimeTag reads a single tag in brackets like "1080p", "1920x1080", "ABCD1234" or "HEVC"
+func (r *Release) applyAnimeTag(tag string) {
+	switch {
+	case crc32Pattern.MatchString(tag) && !isDateTag(tag):
+		r.Crc32 = strings.ToUpper(tag)
+	case dimensionsPattern.MatchString(tag):
+		r.Resolution = dimensionsPattern.FindStringSubmatch(tag)[1] + "p"
+	case resolutionPattern.MatchString(tag):
+		r.Resolution = normalize(strings.ToLower(tag), resolutions)
+	case codecPattern.MatchString(tag):
+		r.Codec = normalize(tag, codecs)
+	case sourcePattern.MatchString(tag) || strings.EqualFold(tag, "BD"):
+		r.Source = normalize(tag, sources)
+	}
+}
+
+// isDateTag reports whether a tag is a date like "20231105" rather than a checksum of digits only
+func isDateTag(tag string) bool {
+	date, err := time.Parse("20060102", tag)
+	return err == nil && date.Year() >= 1900 && date.Year() < 2100
+}
+
+func episodeRange(first int, last int) []int {
+	episodes := []int{}
+	for e := first; e <= last; e++ {
+		episodes = append(episodes, e)
+	}
+	if len(episodes) == 0 {
+		return []int{first}
+	}
+	return episodes
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if len(v) > 0 {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package release
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAnime(t *testing.T) {
+	tests := []struct {
+		name string
+		want Release
+	}{
+		{
+			"[Group] Title - 07v2 [1080p][ABCD1234]",
+			Release{Title: "Title", Absolute: []int{7}, Version: 2, Resolution: "1080p", Group: "Group", Crc32: "ABCD1234"},
+		},
+		{
+			"[Group] Title - 07 (1920x1080 HEVC) [abcd1234]",
+			Release{Title: "Title", Absolute: []int{7}, Resolution: "1080p", Codec: "HEVC", Group: "Group", Crc32: "ABCD1234"},
+		},
+		{
+			"[Group] Title - 07 [1080p][20231105]",
+			Release{Title: "Title", Absolute: []int{7}, Resolution: "1080p", Group: "Group"},
+		},
+		{
+			"[Group] Title - 07 [1080p][20231399]",
+			Release{Title: "Title", Absolute: []int{7}, Resolution: "1080p", Group: "Group", Crc32: "20231399"},
+		},
+		{
+			"[Group] Title - 07 [1080p][12345678]",
+			Release{Title: "Title", Absolute: []int{7}, Resolution: "1080p", Group: "Group", Crc32: "12345678"},
+		},
+		{
+			"[Group] Title - 07 [1080p][1234567A]",
+			Release{Title: "Title", Absolute: []int{7}, Resolution: "1080p", Group: "Group", Crc32: "1234567A"},
+		},
+		{
+			"[Group] Title - The Movie - 1001 [720p]",
+			Release{Title: "Title - The Movie", Absolute: []int{1001}, Resolution: "720p", Group: "Group"},
+		},
+		{
+			"[Group] Title S2 - 03 [1080p]",
+			Release{Title: "Title", Season: 2, HasSeason: true, Absolute: []int{3}, Resolution: "1080p", Group: "Group"},
+		},
+		{
+			"[Group] Title (01-03) [BD 1080p] [Batch]",
+			Release{Title: "Title", Absolute: []int{1, 2, 3}, Resolution: "1080p", Source: "BD", Group: "Group"},
+		},
+		{
+			"[Group] Title - 01 ~ 03 [1080p]",
+			Release{Title: "Title", Absolute: []int{1, 2, 3}, Resolution: "1080p", Group: "Group"},
+		},
+		{
+			"[Group] Title S02E07 [1080p][ABCD1234]",
+			Release{Title: "Title", Season: 2, HasSeason: true, Episodes: []int{7}, Resolution: "1080p", Group: "Group", Crc32: "ABCD1234"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ParseAnime(test.name); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseAnime(%q) = %+v, want %+v", test.name, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package release
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// Matches a series part like "Stormlight Archive 01", "Stormlight Archive, Book 1" or "Stormlight Archive #1"
+	seriesPartPattern = regexp.MustCompile(`(?i)^(.+?)[,\s]+(?:book|vol\.?|volume|#)?\s*(\d+(?:\.\d+)?)$`)
+	// Matches "Title by Author"
+	byAuthorPattern     = regexp.MustCompile(`(?i)^(.+?)\s+by\s+(.+)$`)
+	trailingYearPattern = regexp.MustCompile(`\s*\b(19|20)\d{2}$`)
+)
+
+// Audiobook is the information Audiobookshelf derives from the folder structure of a book
+type Audiobook struct {
+	Author string
+	Series string
+	// SeriesIndex is the position of the book in its series, kept as text as Audiobookshelf allows values like 1.5
+	SeriesIndex string
+	Title       string
+}
+
+// ParseAudiobook extracts author, series and title from release names like
+// "Author - Series 01 - Title", "Author - Title" or "Title by Author".
+func ParseAudiobook(name string) Audiobook {
+	name = trailingYearPattern.ReplaceAllString(cleanName(name), "")
+	parts := strings.Split(name, " - ")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	switch {
+	case len(parts) >= 3:
+		book := Audiobook{Author: parts[0], Title: strings.Join(parts[2:], " - ")}
+		if m := seriesPartPattern.FindStringSubmatch(parts[1]); m != nil {
+			book.Series, book.SeriesIndex = m[1], strings.TrimLeft(m[2], "0")
+			if len(book.SeriesIndex) == 0 || book.SeriesIndex[0] == '.' {
+				book.SeriesIndex = "0" + book.SeriesIndex
+			}
+		} else {
+			book.Series = parts[1]
+		}
+		return book
+	case len(parts) == 2:
+		return Audiobook{Author: parts[0], Title: parts[1]}
+	}
+	if m := byAuthorPattern.FindStringSubmatch(name); m != nil {
+		return Audiobook{Author: m[2], Title: m[1]}
+	}
+	return Audiobook{Title: name}
+}
+
+// Path returns the folder of the book in the layout expected by Audiobookshelf: Author/Series/Index - Title
+func (a Audiobook) Path() string {
+	title := a.Title
+	if len(a.SeriesIndex) > 0 {
+		title = a.SeriesIndex + " - " + title
+	}
+	elements := []string{}
+	for _, e := range []string{a.Author, a.Series, title} {
+		if e = sanitizePathElement(e); len(e) > 0 {
+			elements = append(elements, e)
+		}
+	}
+	return strings.Join(elements, "/")
+}
@@ -0,0 +1,33 @@
+// Package release parses the names of releases into the information needed to sort them into a library
+package release
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// Bracketed tags like "[MP3]", "(Unabridged)" or "{64kbps}"
+	tagPattern = regexp.MustCompile(`\s*[\[({][^\])}]*[\])}]`)
+	spaces     = regexp.MustCompile(`\s+`)
+)
+
+// cleanName strips bracketed tags and replaces dots and underscores used as separators by spaces
+func cleanName(name string) string {
+	name = tagPattern.ReplaceAllString(name, "")
+	if !strings.Contains(name, " ") {
+		name = strings.NewReplacer(".", " ", "_", " ").Replace(name)
+	}
+	return strings.TrimSpace(spaces.ReplaceAllString(name, " "))
+}
+
+// sanitizePathElement removes characters which are not allowed in folder names
+func sanitizePathElement(e string) string {
+	e = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return -1
+		}
+		return r
+	}, e)
+	return strings.Trim(strings.TrimSpace(e), ".")
+}
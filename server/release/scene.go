@@ -0,0 +1,205 @@
+package release
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Matches "S02E05", "S02E05E06", "S02E05-E06" and "S02E05-06"
+	episodePattern = regexp.MustCompile(`(?i)\bS(\d{1,3}) ?E(\d{1,4})((?:-?E\d{1,4})*(?:-\d{1,4})?)\b`)
+	// Matches "2x05" and "2x05-06"
+	crossEpisodePattern = regexp.MustCompile(`(?i)\b(\d{1,2})x(\d{2,3})((?:-\d{2,3})?)\b`)
+	// Matches season packs like "S02" or "Season 2"
+	seasonPattern = regexp.MustCompile(`(?i)\b(?:S|Season )(\d{1,3})\b`)
+	// Matches absolute episode numbers of anime like "Title - 07", "Title - 1001" or "Title - 07v2"
+	absolutePattern   = regexp.MustCompile(`\s-\s(\d{1,4})(?:v\d)?(?:\s|$)`)
+	yearPattern       = regexp.MustCompile(`\b((?:19|20)\d{2})\b`)
+	resolutionPattern = regexp.MustCompile(`(?i)\b(2160p|1080p|1080i|720p|576p|480p|4k|uhd)\b`)
+	sourcePattern     = regexp.MustCompile(`(?i)\b(blu-?ray|bdrip|brrip|remux|web-?dl|web-?rip|web|hdtv|dvdrip|dvd|hdrip)\b`)
+	codecPattern      = regexp.MustCompile(`(?i)\b(x26[45]|h[ .]?26[45]|hevc|avc|xvid|divx|av1|vp9)\b`)
+	// Matches a scene group at the end like "-GRP", optionally followed by tags like "[rarbg]".
+	// It is only taken as group if tokens describing the release come before it, so titles like "Spider-Man" are kept intact.
+	sceneGroupPattern = regexp.MustCompile(`-([A-Za-z0-9]+)(?:\s*\[[^\]]*\])*$`)
+	// Matches a fansub group at the start like "[Group]"
+	fansubGroupPattern = regexp.MustCompile(`^\s*\[([^\]]+)\]`)
+	numberPattern      = regexp.MustCompile(`\d+`)
+	brackets           = strings.NewReplacer("[", " ", "]", " ", "(", " ", ")", " ", "{", " ", "}", " ")
+)
+
+var (
+	resolutions = map[string]string{"4k": "2160p", "uhd": "2160p"}
+	sources     = map[string]string{
+		"bluray": "BluRay", "blu-ray": "BluRay", "bdrip": "BDRip", "brrip": "BRRip", "remux": "Remux",
+		"web-dl": "WEB-DL", "webdl": "WEB-DL", "web-rip": "WEBRip", "webrip": "WEBRip", "web": "WEB",
+		"hdtv": "HDTV", "dvdrip": "DVDRip", "dvd": "DVD", "hdrip": "HDRip",
+	}
+	codecs = map[string]string{
+		"x264": "x264", "x265": "x265", "h264": "H.264", "h265": "H.265", "hevc": "HEVC",
+		"avc": "AVC", "xvid": "XviD", "divx": "DivX", "av1": "AV1", "vp9": "VP9",
+	}
+)
+
+// Release is the information contained in the name of a movie or episode release
+type Release struct {
+	Title string
+	Year  int
+	// Season is only meaningful if HasSeason is set as season 0 is used for specials
+	Season    int
+	HasSeason bool
+	Episodes  []int
+	// Absolute are episode numbers counted across all seasons as used by anime releases
+	Absolute   []int
+	Resolution string
+	Source     string
+	Codec      string
+	Group      string
+	// Version is the revision of a fansub release like "v2", 0 if the release has not been revised
+	Version int
+	// Crc32 is the checksum of the file as tagged by fansub groups
+	Crc32 string
+}
+
+// Parse extracts the information of scene release names like "Show.Name.S02E05.1080p.WEB-DL.x264-GRP"
+// or "Movie.Title.2019.2160p.BluRay.x265-GRP". The name is expected to not include a file extension.
+func Parse(name string) Release {
+	r := Release{}
+	if m := fansubGroupPattern.FindStringSubmatch(name); m != nil {
+		r.Group = strings.TrimSpace(m[1])
+		name = name[len(m[0]):]
+	} else if m := sceneGroupPattern.FindStringSubmatchIndex(name); m != nil {
+		group := name[m[2]:m[3]]
+		if !strings.EqualFold(group, "DL") && !strings.EqualFold(group, "Rip") && !isMediaToken(group) && isReleaseName(name[:m[0]]) {
+			r.Group = group
+			name = name[:m[0]]
+		}
+	}
+	if !strings.Contains(name, " ") {
+		name = strings.NewReplacer(".", " ", "_", " ").Replace(name)
+	}
+	// Bracketed tags are kept as they often contain the year or resolution, e.g. "Movie (2019) [1080p]"
+	name = strings.TrimSpace(spaces.ReplaceAllString(brackets.Replace(name), " "))
+
+	// The title ends where the first token describing the release starts
+	titleEnd := len(name)
+	found := func(loc []int) {
+		if loc != nil && loc[0] < titleEnd {
+			titleEnd = loc[0]
+		}
+	}
+	if m := episodePattern.FindStringSubmatchIndex(name); m != nil {
+		r.Season, r.HasSeason = atoi(name[m[2]:m[3]]), true
+		r.Episodes = episodeNumbers(atoi(name[m[4]:m[5]]), name[m[6]:m[7]])
+		found(m)
+	} else if m := crossEpisodePattern.FindStringSubmatchIndex(name); m != nil {
+		r.Season, r.HasSeason = atoi(name[m[2]:m[3]]), true
+		r.Episodes = episodeNumbers(atoi(name[m[4]:m[5]]), name[m[6]:m[7]])
+		found(m)
+	} else if m := seasonPattern.FindStringSubmatchIndex(name); m != nil {
+		r.Season, r.HasSeason = atoi(name[m[2]:m[3]]), true
+		found(m)
+	} else if m := absolutePattern.FindStringSubmatchIndex(name); m != nil {
+		r.Absolute = []int{atoi(name[m[2]:m[3]])}
+		found(m)
+	}
+	if m := resolutionPattern.FindStringSubmatchIndex(name); m != nil {
+		r.Resolution = normalize(strings.ToLower(name[m[2]:m[3]]), resolutions)
+		found(m)
+	}
+	if m := sourcePattern.FindStringSubmatchIndex(name); m != nil {
+		r.Source = normalize(name[m[2]:m[3]], sources)
+		found(m)
+	}
+	if m := codecPattern.FindStringSubmatchIndex(name); m != nil {
+		r.Codec = normalize(name[m[2]:m[3]], codecs)
+		found(m)
+	}
+	// The last year in front of the other tokens is used so titles like "2001 A Space Odyssey 1968"
+	// or "Blade Runner 2049 2017" are kept intact
+	years := yearPattern.FindAllStringSubmatchIndex(name, -1)
+	for i := len(years) - 1; i >= 0; i-- {
+		if m := years[i]; m[0] > 0 && m[0] <= titleEnd {
+			r.Year = atoi(name[m[2]:m[3]])
+			titleEnd = m[0]
+			break
+		}
+	}
+	r.Title = strings.Trim(name[:titleEnd], " -(")
+	return r
+}
+
+// Merge fills the fields which are not set with the values of another release,
+// e.g. to complete the information of an episode file with the name of its season pack.
+func (r Release) Merge(other Release) Release {
+	if len(r.Title) == 0 {
+		r.Title = other.Title
+	}
+	if r.Year == 0 {
+		r.Year = other.Year
+	}
+	if !r.HasSeason {
+		r.Season, r.HasSeason = other.Season, other.HasSeason
+	}
+	if len(r.Episodes) == 0 {
+		r.Episodes = other.Episodes
+	}
+	if len(r.Absolute) == 0 {
+		r.Absolute = other.Absolute
+	}
+	if len(r.Resolution) == 0 {
+		r.Resolution = other.Resolution
+	}
+	if len(r.Source) == 0 {
+		r.Source = other.Source
+	}
+	if len(r.Codec) == 0 {
+		r.Codec = other.Codec
+	}
+	if len(r.Group) == 0 {
+		r.Group = other.Group
+	}
+	if r.Version == 0 {
+		r.Version = other.Version
+	}
+	return r
+}
+
+// episodeNumbers expands the episodes following the first one, either a list like "E06E07" or a range like "-E08"
+func episodeNumbers(first int, rest string) []int {
+	episodes := []int{first}
+	numbers := numberPattern.FindAllString(rest, -1)
+	if len(numbers) == 1 && strings.HasPrefix(rest, "-") {
+		for e := first + 1; e <= atoi(numbers[0]); e++ {
+			episodes = append(episodes, e)
+		}
+		return episodes
+	}
+	for _, n := range numbers {
+		episodes = append(episodes, atoi(n))
+	}
+	return episodes
+}
+
+// isReleaseName reports if the name contains tokens describing a release like the resolution or an episode
+func isReleaseName(name string) bool {
+	name = strings.NewReplacer(".", " ", "_", " ").Replace(name)
+	return isMediaToken(name) || episodePattern.MatchString(name)
+}
+
+func isMediaToken(s string) bool {
+	return resolutionPattern.MatchString(s) || sourcePattern.MatchString(s) || codecPattern.MatchString(s)
+}
+
+func normalize(token string, known map[string]string) string {
+	key := strings.NewReplacer(" ", "", ".", "").Replace(strings.ToLower(token))
+	if n, ok := known[key]; ok {
+		return n
+	}
+	return token
+}
+
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
@@ -0,0 +1,103 @@
+package release
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		want Release
+	}{
+		{
+			"Show.Name.S02E05.1080p.WEB-DL.x264-GRP",
+			Release{Title: "Show Name", Season: 2, HasSeason: true, Episodes: []int{5}, Resolution: "1080p", Source: "WEB-DL", Codec: "x264", Group: "GRP"},
+		},
+		{
+			"Movie.Title.2019.2160p.BluRay.x265-GRP",
+			Release{Title: "Movie Title", Year: 2019, Resolution: "2160p", Source: "BluRay", Codec: "x265", Group: "GRP"},
+		},
+		{
+			"Blade.Runner.2049.2017.2160p.BluRay",
+			Release{Title: "Blade Runner 2049", Year: 2017, Resolution: "2160p", Source: "BluRay"},
+		},
+		{
+			"Blade Runner 2049 (2017)",
+			Release{Title: "Blade Runner 2049", Year: 2017},
+		},
+		{
+			"2001.A.Space.Odyssey.1968.1080p.BluRay.x264-GRP",
+			Release{Title: "2001 A Space Odyssey", Year: 1968, Resolution: "1080p", Source: "BluRay", Codec: "x264", Group: "GRP"},
+		},
+		{
+			"Spider-Man",
+			Release{Title: "Spider-Man"},
+		},
+		{
+			"Spider-Man (2002)",
+			Release{Title: "Spider-Man", Year: 2002},
+		},
+		{
+			"Spider-Man.No.Way.Home.2021.1080p.WEB-DL.x264-GRP",
+			Release{Title: "Spider-Man No Way Home", Year: 2021, Resolution: "1080p", Source: "WEB-DL", Codec: "x264", Group: "GRP"},
+		},
+		{
+			"Movie.2019.1080p.WEB-DL",
+			Release{Title: "Movie", Year: 2019, Resolution: "1080p", Source: "WEB-DL"},
+		},
+		{
+			"Movie.2019.720p.BluRay.x264-GRP [rarbg]",
+			Release{Title: "Movie", Year: 2019, Resolution: "720p", Source: "BluRay", Codec: "x264", Group: "GRP"},
+		},
+		{
+			"Movie (2019) [1080p]",
+			Release{Title: "Movie", Year: 2019, Resolution: "1080p"},
+		},
+		{
+			"Show.Name.S01E01-GRP",
+			Release{Title: "Show Name", Season: 1, HasSeason: true, Episodes: []int{1}, Group: "GRP"},
+		},
+		{
+			"Show.S01E01E02.720p.HDTV.x264-GRP",
+			Release{Title: "Show", Season: 1, HasSeason: true, Episodes: []int{1, 2}, Resolution: "720p", Source: "HDTV", Codec: "x264", Group: "GRP"},
+		},
+		{
+			"Show S01E01-E03 720p HDTV",
+			Release{Title: "Show", Season: 1, HasSeason: true, Episodes: []int{1, 2, 3}, Resolution: "720p", Source: "HDTV"},
+		},
+		{
+			"Show.2x05.HDTV",
+			Release{Title: "Show", Season: 2, HasSeason: true, Episodes: []int{5}, Source: "HDTV"},
+		},
+		{
+			"Show.S00E03.1080p",
+			Release{Title: "Show", Season: 0, HasSeason: true, Episodes: []int{3}, Resolution: "1080p"},
+		},
+		{
+			"Show.Name.S03.1080p.BluRay-GRP",
+			Release{Title: "Show Name", Season: 3, HasSeason: true, Resolution: "1080p", Source: "BluRay", Group: "GRP"},
+		},
+		{
+			"[Group] Title - 07",
+			Release{Title: "Title", Absolute: []int{7}, Group: "Group"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Parse(test.name); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", test.name, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	episode := Release{Episodes: []int{5}, Season: 1, HasSeason: true}
+	pack := Release{Title: "Show", Year: 2020, Season: 2, HasSeason: true, Resolution: "1080p", Group: "GRP"}
+
+	want := Release{Title: "Show", Year: 2020, Season: 1, HasSeason: true, Episodes: []int{5}, Resolution: "1080p", Group: "GRP"}
+	if got := episode.Merge(pack); !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,143 @@
+package release
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// Matches placeholders like "{Title}" or "{Season:00}"
+	placeholderPattern = regexp.MustCompile(`\{([A-Za-z][A-Za-z0-9]*)(?::(0+))?\}`)
+	// Matches brackets left empty by placeholders without a value
+	emptyBracketsPattern = regexp.MustCompile(`\(\s*\)|\[\s*\]`)
+	// Matches separators left in front of the extension, e.g. "Movie - .mkv"
+	danglingSeparatorPattern = regexp.MustCompile(`[\s-]+(\.[^.\s]+)$`)
+)
+
+// Values are the values of a release available to templates
+type Values struct {
+	Release
+	// Ext is the extension of the file without the leading dot
+	Ext string
+	// Original is the name of the file without extension
+	Original string
+}
+
+// placeholders renders the value of a placeholder, zero padding numbers to the given width
+var placeholders = map[string]func(v Values, width int) string{
+	"Title":      func(v Values, _ int) string { return v.Title },
+	"Series":     func(v Values, _ int) string { return v.Title },
+	"Year":       func(v Values, width int) string { return number(v.Year, v.Year > 0, width) },
+	"Season":     func(v Values, width int) string { return number(v.Season, v.HasSeason, width) },
+	"Episode":    func(v Values, width int) string { return numbers(v.Episodes, "-E", width) },
+	"Absolute":   func(v Values, width int) string { return numbers(v.Absolute, "-", width) },
+	"Resolution": func(v Values, _ int) string { return v.Resolution },
+	"Source":     func(v Values, _ int) string { return v.Source },
+	"Codec":      func(v Values, _ int) string { return v.Codec },
+	"Group":      func(v Values, _ int) string { return v.Group },
+	"Version":    func(v Values, _ int) string { return version(v.Version) },
+	"Crc32":      func(v Values, _ int) string { return v.Crc32 },
+	"Original":   func(v Values, _ int) string { return v.Original },
+	"ext":        func(v Values, _ int) string { return v.Ext },
+}
+
+// Template renders the path of a file from its release, e.g. "{Series}/Season {Season:00}/{Series} - S{Season:00}E{Episode:00}.{ext}"
+type Template struct {
+	template string
+}
+
+func ParseTemplate(template string) (Template, error) {
+	for _, m := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		if _, ok := placeholders[m[1]]; !ok {
+			return Template{}, fmt.Errorf("unknown placeholder %s", m[0])
+		}
+	}
+	if strings.Contains(template, "\\") {
+		return Template{}, fmt.Errorf("must use forward slashes")
+	}
+	return Template{template: template}, nil
+}
+
+// Render returns the relative path of a file. Placeholders without a value are left out together with
+// the text attached to them, like the "S" of "S{Season:00}", and the brackets and separators surrounding them.
+// Folders whose placeholders all have no value are left out, file names fall back to the original name.
+func (t Template) Render(v Values) string {
+	segments := strings.Split(t.template, "/")
+	cleaned := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		s, count, filled := renderSegment(segment, v)
+		if count > 0 && filled == 0 {
+			if i < len(segments)-1 {
+				continue
+			}
+			s = sanitizePathElement(v.Original)
+			if len(v.Ext) > 0 {
+				s += "." + v.Ext
+			}
+		}
+		s = emptyBracketsPattern.ReplaceAllString(s, "")
+		s = danglingSeparatorPattern.ReplaceAllString(spaces.ReplaceAllString(s, " "), "$1")
+		s = strings.Trim(s, " -")
+		if len(s) > 0 && s != "." {
+			cleaned = append(cleaned, s)
+		}
+	}
+	return strings.Join(cleaned, "/")
+}
+
+// renderSegment renders the placeholders of a single folder or file name. The number of placeholders
+// and how many of them have a value are returned as well, the extension is not counted.
+func renderSegment(segment string, v Values) (string, int, int) {
+	var rendered strings.Builder
+	count, filled, end := 0, 0, 0
+	for _, m := range placeholderPattern.FindAllStringSubmatchIndex(segment, -1) {
+		name := segment[m[2]:m[3]]
+		width := 0
+		if m[4] >= 0 {
+			width = m[5] - m[4]
+		}
+		value := sanitizePathElement(placeholders[name](v, width))
+		literal := segment[end:m[0]]
+		if len(value) == 0 {
+			literal = strings.TrimRightFunc(literal, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) })
+		}
+		if name != "ext" {
+			count++
+			if len(value) > 0 {
+				filled++
+			}
+		}
+		rendered.WriteString(literal)
+		rendered.WriteString(value)
+		end = m[1]
+	}
+	rendered.WriteString(segment[end:])
+	return rendered.String(), count, filled
+}
+
+func number(n int, ok bool, width int) string {
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%0*d", width, n)
+}
+
+func version(v int) string {
+	if v == 0 {
+		return ""
+	}
+	return fmt.Sprintf("v%d", v)
+}
+
+// numbers renders episode lists as ranges like "05-E07" as understood by Plex, Jellyfin and Emby
+func numbers(n []int, separator string, width int) string {
+	switch len(n) {
+	case 0:
+		return ""
+	case 1:
+		return number(n[0], true, width)
+	}
+	return number(n[0], true, width) + separator + number(n[len(n)-1], true, width)
+}
@@ -0,0 +1,51 @@
+package release
+
+import "testing"
+
+func TestTemplateRender(t *testing.T) {
+	episode := Values{
+		Release:  Release{Title: "Show", Year: 2020, Season: 2, HasSeason: true, Episodes: []int{5, 6}, Resolution: "1080p"},
+		Ext:      "mkv",
+		Original: "show.s02e05e06.1080p",
+	}
+	movie := Values{
+		Release:  Release{Title: "Movie: The Sequel", Resolution: "2160p"},
+		Ext:      "mkv",
+		Original: "movie.2160p",
+	}
+	tests := []struct {
+		template string
+		values   Values
+		want     string
+	}{
+		{"{Series}/Season {Season:00}/{Series} - S{Season:00}E{Episode:00}.{ext}", episode, "Show/Season 02/Show - S02E05-E06.mkv"},
+		{"{Title} ({Year})/{Title} ({Year}) [{Resolution}].{ext}", episode, "Show (2020)/Show (2020) [1080p].mkv"},
+		{"{Series}/{Original}.{ext}", episode, "Show/show.s02e05e06.1080p.mkv"},
+		// Placeholders without a value are left out with the brackets, separators and text attached to them
+		{"{Title} ({Year})/{Title} ({Year}) - {Codec}.{ext}", movie, "Movie The Sequel/Movie The Sequel.mkv"},
+		{"{Series}/Season {Season:00}/{Series} - S{Season:00}E{Episode:00}.{ext}", movie, "Movie The Sequel/Movie The Sequel.mkv"},
+		{"{Title} - Part{Absolute}.{ext}", movie, "Movie The Sequel.mkv"},
+		// File names without any value fall back to the original name
+		{"Season {Season:00}/S{Season:00}E{Episode:00}.{ext}", movie, "movie.2160p.mkv"},
+		{"{Title}/{Group}.{ext}", Values{Release: Release{Title: "Movie"}, Original: "movie"}, "Movie/movie"},
+	}
+	for _, test := range tests {
+		t.Run(test.template, func(t *testing.T) {
+			template, err := ParseTemplate(test.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := template.Render(test.values); got != test.want {
+				t.Errorf("Render() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	for _, template := range []string{"{Title}/{Unknown}.{ext}", `{Title}\{Title}.{ext}`} {
+		if _, err := ParseTemplate(template); err == nil {
+			t.Errorf("ParseTemplate(%q) succeeded, want an error", template)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	defaultApiUrl string = "https://api.telegram.org"
+)
+
+type user struct {
+	Id       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+type chat struct {
+	Id int64 `json:"id"`
+}
+
+type document struct {
+	FileId   string `json:"file_id"`
+	FileName string `json:"file_name"`
+	FileSize int64  `json:"file_size"`
+}
+
+type message struct {
+	MessageId int64     `json:"message_id"`
+	From      *user     `json:"from"`
+	Chat      chat      `json:"chat"`
+	Text      string    `json:"text"`
+	Caption   string    `json:"caption"`
+	Document  *document `json:"document"`
+}
+
+type callbackQuery struct {
+	Id      string   `json:"id"`
+	From    user     `json:"from"`
+	Message *message `json:"message"`
+	Data    string   `json:"data"`
+}
+
+type update struct {
+	UpdateId      int64          `json:"update_id"`
+	Message       *message       `json:"message"`
+	CallbackQuery *callbackQuery `json:"callback_query"`
+}
+
+type file struct {
+	FilePath string `json:"file_path"`
+}
+
+type inlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type inlineKeyboardMarkup struct {
+	InlineKeyboard [][]inlineKeyboardButton `json:"inline_keyboard"`
+}
+
+type sendMessageParams struct {
+	ChatId           int64                 `json:"chat_id"`
+	Text             string                `json:"text"`
+	ReplyToMessageId int64                 `json:"reply_to_message_id,omitempty"`
+	ReplyMarkup      *inlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+type editMessageTextParams struct {
+	ChatId    int64  `json:"chat_id"`
+	MessageId int64  `json:"message_id"`
+	Text      string `json:"text"`
+}
+
+type answerCallbackQueryParams struct {
+	CallbackQueryId string `json:"callback_query_id"`
+	Text            string `json:"text,omitempty"`
+}
+
+type getUpdatesParams struct {
+	Offset         int64    `json:"offset"`
+	Timeout        int      `json:"timeout"`
+	AllowedUpdates []string `json:"allowed_updates"`
+}
+
+type getFileParams struct {
+	FileId string `json:"file_id"`
+}
+
+type apiResponse struct {
+	Ok          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	Description string          `json:"description"`
+}
+
+// botApi is a minimal client for the methods of the Telegram Bot API used by the bot
+type botApi struct {
+	client  *http.Client
+	baseUrl string
+	token   string
+}
+
+func newBotApi(baseUrl string, token string) botApi {
+	if len(baseUrl) == 0 {
+		baseUrl = defaultApiUrl
+	}
+	return botApi{
+		client:  &http.Client{},
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		token:   token,
+	}
+}
+
+func (b botApi) call(ctx context.Context, method string, params any, result any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/bot%s/%s", b.baseUrl, b.token, method), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rsp, err := b.client.Do(req)
+	if err != nil {
+		// The error contains the URL including the token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("calling %s: %w", method, urlErr.Err)
+		}
+		return err
+	}
+	defer rsp.Body.Close()
+
+	var apiRsp apiResponse
+	if err := json.NewDecoder(rsp.Body).Decode(&apiRsp); err != nil {
+		return fmt.Errorf("calling %s: %w", method, err)
+	}
+	if !apiRsp.Ok {
+		return fmt.Errorf("calling %s: %s", method, apiRsp.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(apiRsp.Result, result)
+}
+
+func (b botApi) getUpdates(ctx context.Context, offset int64, timeout int) ([]update, error) {
+	var updates []update
+	err := b.call(ctx, "getUpdates", getUpdatesParams{
+		Offset:         offset,
+		Timeout:        timeout,
+		AllowedUpdates: []string{"message", "callback_query"},
+	}, &updates)
+	return updates, err
+}
+
+func (b botApi) sendMessage(ctx context.Context, params sendMessageParams) error {
+	return b.call(ctx, "sendMessage", params, nil)
+}
+
+func (b botApi) editMessageText(ctx context.Context, params editMessageTextParams) error {
+	return b.call(ctx, "editMessageText", params, nil)
+}
+
+func (b botApi) answerCallbackQuery(ctx context.Context, params answerCallbackQueryParams) error {
+	return b.call(ctx, "answerCallbackQuery", params, nil)
+}
+
+// downloadFile fetches the content of a file sent to the bot, up to maxSize bytes
+func (b botApi) downloadFile(ctx context.Context, fileId string, maxSize int64) ([]byte, error) {
+	var f file
+	if err := b.call(ctx, "getFile", getFileParams{FileId: fileId}, &f); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/file/bot%s/%s", b.baseUrl, b.token, f.FilePath), nil)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := b.client.Do(req)
+	if err != nil {
+		return nil, errors.New("downloading file failed")
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading file failed with status %s", rsp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(rsp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxSize {
+		return nil, fmt.Errorf("file exceeds %d bytes", maxSize)
+	}
+	return content, nil
+}
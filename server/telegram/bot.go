@@ -0,0 +1,340 @@
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/events"
+	"github.com/bongofriend/torrent-ingest/models"
+	"github.com/bongofriend/torrent-ingest/torrent"
+	"github.com/bongofriend/torrent-ingest/ytdlp"
+)
+
+const (
+	pollTimeoutSeconds  int           = 30
+	retryDelay          time.Duration = 5 * time.Second
+	requestTimeout      time.Duration = 10 * time.Second
+	pendingSubmitMaxAge time.Duration = 1 * time.Hour
+	torrentFileMaxSize  int64         = 1 * 1024 * 1024 //1 MB file limit, same as the API
+	callbackSeparator   string        = ":"
+	// Jobs which did not end within jobReplyMaxAge, e.g. torrents without seeders, are not replied to anymore
+	jobReplyMaxAge time.Duration = 7 * 24 * time.Hour
+)
+
+var (
+	magnetLinkPattern = regexp.MustCompile(`magnet:\?\S+`)
+	urlPattern        = regexp.MustCompile(`https?://\S+`)
+
+	categories = []models.MediaCategory{models.Movies, models.Series, models.Anime, models.Music, models.Audiobook}
+
+	errNothingToSubmit = errors.New("nothing to submit")
+)
+
+type submissionKind int
+
+const (
+	magnetLinkSubmission submissionKind = iota
+	torrentFileSubmission
+	ytdlpSubmission
+)
+
+// pendingSubmission is a download sent to the bot which waits for the user to pick a category
+type pendingSubmission struct {
+	kind      submissionKind
+	value     string
+	content   []byte
+	chatId    int64
+	messageId int64
+	created   time.Time
+}
+
+// chatReference is the chat a job was submitted from, notified once the job finished
+type chatReference struct {
+	chatId    int64
+	messageId int64
+	submitted time.Time
+}
+
+type Bot interface {
+	events.Listener
+	Start(ctx context.Context)
+}
+
+type bot struct {
+	api                  botApi
+	allowedUsers         []int64
+	transmissionClient   torrent.TransmissionClient
+	ytdlpDownloadService ytdlp.YtdlpDownloadService
+
+	mu      *sync.Mutex
+	pending map[string]pendingSubmission
+	jobs    map[string]chatReference
+}
+
+func NewBot(telegramConfig config.TelegramConfig, transmissionClient torrent.TransmissionClient, ytdlpDownloadService ytdlp.YtdlpDownloadService) Bot {
+	return bot{
+		api:                  newBotApi(telegramConfig.ApiUrl, telegramConfig.Token),
+		allowedUsers:         telegramConfig.AllowedUsers,
+		transmissionClient:   transmissionClient,
+		ytdlpDownloadService: ytdlpDownloadService,
+		mu:                   &sync.Mutex{},
+		pending:              map[string]pendingSubmission{},
+		jobs:                 map[string]chatReference{},
+	}
+}
+
+// Start implements Bot by long polling for updates until the context is cancelled.
+func (b bot) Start(ctx context.Context) {
+	var offset int64
+	for {
+		b.prune(time.Now())
+		pollCtx, cancel := context.WithTimeout(ctx, time.Duration(pollTimeoutSeconds)*time.Second+requestTimeout)
+		updates, err := b.api.getUpdates(pollCtx, offset, pollTimeoutSeconds)
+		cancel()
+		if ctx.Err() != nil {
+			log.Println("Telegram bot stopped")
+			return
+		}
+		if err != nil {
+			log.Printf("Polling Telegram updates failed: %s", err)
+			select {
+			case <-ctx.Done():
+				log.Println("Telegram bot stopped")
+				return
+			case <-time.After(retryDelay):
+				continue
+			}
+		}
+		for _, u := range updates {
+			offset = u.UpdateId + 1
+			b.handleUpdate(ctx, u)
+		}
+	}
+}
+
+func (b bot) handleUpdate(ctx context.Context, u update) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	var err error
+	switch {
+	case u.Message != nil && u.Message.From != nil:
+		if !slices.Contains(b.allowedUsers, u.Message.From.Id) {
+			log.Printf("Ignoring Telegram message from user %d (%s)", u.Message.From.Id, u.Message.From.Username)
+			return
+		}
+		err = b.handleMessage(ctx, *u.Message)
+	case u.CallbackQuery != nil:
+		if !slices.Contains(b.allowedUsers, u.CallbackQuery.From.Id) {
+			log.Printf("Ignoring Telegram callback from user %d (%s)", u.CallbackQuery.From.Id, u.CallbackQuery.From.Username)
+			return
+		}
+		err = b.handleCallback(ctx, *u.CallbackQuery)
+	}
+	if err != nil {
+		log.Printf("Handling Telegram update %d failed: %s", u.UpdateId, err)
+	}
+}
+
+func (b bot) handleMessage(ctx context.Context, m message) error {
+	submission, err := b.parseSubmission(ctx, m)
+	if errors.Is(err, errNothingToSubmit) {
+		return b.reply(ctx, m, "Send a magnet link, a .torrent file or a URL to download")
+	}
+	if err != nil {
+		b.reply(ctx, m, fmt.Sprintf("Could not read your message: %s", err))
+		return err
+	}
+
+	id := b.addPending(submission)
+	keyboard := &inlineKeyboardMarkup{}
+	row := []inlineKeyboardButton{}
+	for _, c := range categories {
+		row = append(row, inlineKeyboardButton{Text: string(c), CallbackData: id + callbackSeparator + string(c)})
+		if len(row) == 3 {
+			keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
+			row = []inlineKeyboardButton{}
+		}
+	}
+	if len(row) > 0 {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
+	}
+	return b.api.sendMessage(ctx, sendMessageParams{
+		ChatId:           m.Chat.Id,
+		Text:             "Choose a category",
+		ReplyToMessageId: m.MessageId,
+		ReplyMarkup:      keyboard,
+	})
+}
+
+func (b bot) parseSubmission(ctx context.Context, m message) (pendingSubmission, error) {
+	submission := pendingSubmission{
+		chatId:    m.Chat.Id,
+		messageId: m.MessageId,
+		created:   time.Now(),
+	}
+	text := m.Text + " " + m.Caption
+	switch {
+	case m.Document != nil && strings.HasSuffix(strings.ToLower(m.Document.FileName), ".torrent"):
+		content, err := b.api.downloadFile(ctx, m.Document.FileId, torrentFileMaxSize)
+		if err != nil {
+			return submission, err
+		}
+		submission.kind = torrentFileSubmission
+		submission.value = m.Document.FileName
+		submission.content = content
+	case magnetLinkPattern.MatchString(text):
+		submission.kind = magnetLinkSubmission
+		submission.value = magnetLinkPattern.FindString(text)
+	case urlPattern.MatchString(text):
+		submission.kind = ytdlpSubmission
+		submission.value = urlPattern.FindString(text)
+	default:
+		return submission, errNothingToSubmit
+	}
+	return submission, nil
+}
+
+func (b bot) handleCallback(ctx context.Context, q callbackQuery) error {
+	id, category, _ := strings.Cut(q.Data, callbackSeparator)
+	submission, ok := b.takePending(id)
+	if !ok {
+		return b.api.answerCallbackQuery(ctx, answerCallbackQueryParams{CallbackQueryId: q.Id, Text: "This download has expired, please send it again"})
+	}
+
+	text := fmt.Sprintf("Submitted as %s", category)
+	jobId, err := b.submit(ctx, submission, models.MediaCategory(category))
+	if err != nil {
+		log.Printf("Submitting Telegram download failed: %s", err)
+		text = fmt.Sprintf("Submitting failed: %s", err)
+	} else {
+		b.mu.Lock()
+		b.jobs[jobId] = chatReference{chatId: submission.chatId, messageId: submission.messageId, submitted: time.Now()}
+		b.mu.Unlock()
+	}
+	if err := b.api.answerCallbackQuery(ctx, answerCallbackQueryParams{CallbackQueryId: q.Id}); err != nil {
+		return err
+	}
+	if q.Message == nil {
+		return nil
+	}
+	return b.api.editMessageText(ctx, editMessageTextParams{
+		ChatId:    q.Message.Chat.Id,
+		MessageId: q.Message.MessageId,
+		Text:      text,
+	})
+}
+
+// submit hands the download over to Transmission or yt-dlp and returns the id of the resulting job
+func (b bot) submit(ctx context.Context, submission pendingSubmission, category models.MediaCategory) (string, error) {
+	if err := category.Validate(); err != nil {
+		return "", err
+	}
+	switch submission.kind {
+	case magnetLinkSubmission:
+		added, err := b.transmissionClient.AddMagnetLink(ctx, torrent.AddMagnetLinkRequest{
+			Category:   category,
+			MagnetLink: submission.value,
+		})
+		return added.Hash, err
+	case torrentFileSubmission:
+		added, err := b.transmissionClient.AddTorrentFile(ctx, torrent.AddTorrentFileRequest{
+			Category:           category,
+			TorrentFileContent: submission.content,
+		})
+		return added.Hash, err
+	default:
+		return b.ytdlpDownloadService.QueueDownload(ctx, ytdlp.AddDownloadRequest{
+			Url:      submission.value,
+			UrlType:  ytdlp.UrlTypeOf(submission.value),
+			Category: category,
+		})
+	}
+}
+
+func (b bot) addPending(submission pendingSubmission) string {
+	idBytes := make([]byte, 8)
+	_, _ = rand.Read(idBytes)
+	id := hex.EncodeToString(idBytes)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending[id] = submission
+	return id
+}
+
+func (b bot) takePending(id string) (pendingSubmission, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	submission, ok := b.pending[id]
+	delete(b.pending, id)
+	return submission, ok
+}
+
+// prune drops the submissions nobody picked a category for and the jobs which did not end in time
+func (b bot) prune(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, p := range b.pending {
+		if now.Sub(p.created) > pendingSubmitMaxAge {
+			delete(b.pending, id)
+		}
+	}
+	for id, ref := range b.jobs {
+		if now.Sub(ref.submitted) > jobReplyMaxAge {
+			delete(b.jobs, id)
+		}
+	}
+}
+
+func (b bot) reply(ctx context.Context, m message, text string) error {
+	return b.api.sendMessage(ctx, sendMessageParams{
+		ChatId:           m.Chat.Id,
+		Text:             text,
+		ReplyToMessageId: m.MessageId,
+	})
+}
+
+// Handle implements events.Listener by telling the chat a job was submitted from how it ended.
+func (b bot) Handle(ctx context.Context, event events.Event) {
+	if event.Type != models.ImportedEvent && event.Type != models.FailedEvent && event.Type != models.LowSpaceEvent {
+		return
+	}
+	b.mu.Lock()
+	ref, ok := b.jobs[event.Job.Id]
+	// A paused job is still waiting to end
+	if event.Type != models.LowSpaceEvent {
+		delete(b.jobs, event.Job.Id)
+	}
+	b.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	text := fmt.Sprintf("Imported %s (%d file(s)) into %s", event.Job.Name, len(event.Job.Result.Imported), event.Job.Category)
+	switch event.Type {
+	case models.FailedEvent:
+		text = fmt.Sprintf("Failed %s: %s", event.Job.Name, event.Job.Error)
+	case models.LowSpaceEvent:
+		text = fmt.Sprintf("Paused %s: %s", event.Job.Name, event.Job.Paused)
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	if err := b.api.sendMessage(ctx, sendMessageParams{
+		ChatId:           ref.chatId,
+		Text:             text,
+		ReplyToMessageId: ref.messageId,
+	}); err != nil {
+		log.Printf("Sending Telegram reply for job %s failed: %s", event.Job.Id, err)
+	}
+}
@@ -0,0 +1,545 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/events"
+	"github.com/bongofriend/torrent-ingest/models"
+	"github.com/bongofriend/torrent-ingest/torrent"
+	"github.com/bongofriend/torrent-ingest/ytdlp"
+)
+
+const (
+	testToken       string = "123:secret"
+	allowedUserId   int64  = 1
+	rejectedUserId  int64  = 2
+	testChatId      int64  = 10
+	testMagnetLink  string = "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567&dn=Movie"
+	testTorrentHash string = "0123456789abcdef0123456789abcdef01234567"
+)
+
+// apiCall is a request to a Bot API method received by the fake API
+type apiCall struct {
+	method string
+	body   json.RawMessage
+}
+
+// fakeBotApi answers the Bot API methods used by the bot. Updates are handed out once by getUpdates, files are served by their path.
+type fakeBotApi struct {
+	server  *httptest.Server
+	mu      *sync.Mutex
+	calls   []apiCall
+	updates []update
+	files   map[string][]byte
+}
+
+func newFakeBotApi(t *testing.T) *fakeBotApi {
+	f := &fakeBotApi{
+		mu:    &sync.Mutex{},
+		files: map[string][]byte{},
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeBotApi) serve(w http.ResponseWriter, r *http.Request) {
+	if path, ok := strings.CutPrefix(r.URL.Path, "/file/bot"+testToken+"/"); ok {
+		f.mu.Lock()
+		content, found := f.files[path]
+		f.mu.Unlock()
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+		return
+	}
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+testToken+"/")
+	if !ok {
+		json.NewEncoder(w).Encode(apiResponse{Ok: false, Description: "Unauthorized"})
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	f.calls = append(f.calls, apiCall{method: method, body: body})
+	var result any = true
+	idle := false
+	switch method {
+	case "getUpdates":
+		params := getUpdatesParams{}
+		json.Unmarshal(body, &params)
+		pending := []update{}
+		for _, u := range f.updates {
+			if u.UpdateId >= params.Offset {
+				pending = append(pending, u)
+			}
+		}
+		result = pending
+		idle = len(pending) == 0
+	case "getFile":
+		params := getFileParams{}
+		json.Unmarshal(body, &params)
+		result = file{FilePath: "documents/" + params.FileId}
+	}
+	f.mu.Unlock()
+	if idle {
+		// Long polling without updates
+		time.Sleep(10 * time.Millisecond)
+	}
+	raw, _ := json.Marshal(result)
+	json.NewEncoder(w).Encode(apiResponse{Ok: true, Result: raw})
+}
+
+// callsTo returns the bodies of the calls to a method
+func (f *fakeBotApi) callsTo(method string) []json.RawMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bodies := []json.RawMessage{}
+	for _, c := range f.calls {
+		if c.method == method {
+			bodies = append(bodies, c.body)
+		}
+	}
+	return bodies
+}
+
+// sentMessages returns the messages sent by the bot
+func (f *fakeBotApi) sentMessages(t *testing.T) []sendMessageParams {
+	sent := []sendMessageParams{}
+	for _, body := range f.callsTo("sendMessage") {
+		params := sendMessageParams{}
+		if err := json.Unmarshal(body, &params); err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, params)
+	}
+	return sent
+}
+
+type fakeTransmissionClient struct {
+	torrent.TransmissionClient
+	mu           *sync.Mutex
+	magnetLinks  []torrent.AddMagnetLinkRequest
+	torrentFiles []torrent.AddTorrentFileRequest
+}
+
+func (f *fakeTransmissionClient) AddMagnetLink(ctx context.Context, req torrent.AddMagnetLinkRequest) (torrent.AddedTorrent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.magnetLinks = append(f.magnetLinks, req)
+	return torrent.AddedTorrent{Hash: testTorrentHash, Category: req.Category}, nil
+}
+
+func (f *fakeTransmissionClient) AddTorrentFile(ctx context.Context, req torrent.AddTorrentFileRequest) (torrent.AddedTorrent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.torrentFiles = append(f.torrentFiles, req)
+	return torrent.AddedTorrent{Hash: testTorrentHash, Category: req.Category}, nil
+}
+
+type fakeYtdlpService struct {
+	mu       *sync.Mutex
+	requests []ytdlp.AddDownloadRequest
+	err      error
+}
+
+func (f *fakeYtdlpService) QueueDownload(ctx context.Context, request ytdlp.AddDownloadRequest) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return "", f.err
+	}
+	f.requests = append(f.requests, request)
+	return "ytdlp-job", nil
+}
+
+type testBot struct {
+	bot
+	server       *fakeBotApi
+	transmission *fakeTransmissionClient
+	ytdlp        *fakeYtdlpService
+}
+
+func newTestBot(t *testing.T) testBot {
+	api := newFakeBotApi(t)
+	transmission := &fakeTransmissionClient{mu: &sync.Mutex{}}
+	ytdlpService := &fakeYtdlpService{mu: &sync.Mutex{}}
+	b := NewBot(config.TelegramConfig{
+		Token:        testToken,
+		ApiUrl:       api.server.URL,
+		AllowedUsers: []int64{allowedUserId},
+	}, transmission, ytdlpService)
+	return testBot{
+		bot:          b.(bot),
+		server:       api,
+		transmission: transmission,
+		ytdlp:        ytdlpService,
+	}
+}
+
+func textMessage(from int64, messageId int64, text string) update {
+	return update{
+		UpdateId: messageId,
+		Message: &message{
+			MessageId: messageId,
+			From:      &user{Id: from, Username: "user"},
+			Chat:      chat{Id: testChatId},
+			Text:      text,
+		},
+	}
+}
+
+func documentMessage(messageId int64, fileId string, fileName string) update {
+	return update{
+		UpdateId: messageId,
+		Message: &message{
+			MessageId: messageId,
+			From:      &user{Id: allowedUserId},
+			Chat:      chat{Id: testChatId},
+			Document:  &document{FileId: fileId, FileName: fileName},
+		},
+	}
+}
+
+func callback(from int64, data string) update {
+	return update{
+		UpdateId: 100,
+		CallbackQuery: &callbackQuery{
+			Id:      "query",
+			From:    user{Id: from},
+			Message: &message{MessageId: 50, Chat: chat{Id: testChatId}},
+			Data:    data,
+		},
+	}
+}
+
+// chooseCategory answers the category keyboard of the last sent message
+func (b testBot) chooseCategory(t *testing.T, category models.MediaCategory) {
+	sent := b.server.sentMessages(t)
+	if len(sent) == 0 || sent[len(sent)-1].ReplyMarkup == nil {
+		t.Fatal("no category keyboard was sent")
+	}
+	for _, row := range sent[len(sent)-1].ReplyMarkup.InlineKeyboard {
+		for _, button := range row {
+			if button.Text == string(category) {
+				b.handleUpdate(context.Background(), callback(allowedUserId, button.CallbackData))
+				return
+			}
+		}
+	}
+	t.Fatalf("keyboard has no button for %s", category)
+}
+
+func (b testBot) editedText(t *testing.T) string {
+	edits := b.server.callsTo("editMessageText")
+	if len(edits) != 1 {
+		t.Fatalf("got %d edits of the keyboard message, want 1", len(edits))
+	}
+	params := editMessageTextParams{}
+	json.Unmarshal(edits[0], &params)
+	return params.Text
+}
+
+func TestIgnoresRejectedUsers(t *testing.T) {
+	b := newTestBot(t)
+
+	b.handleUpdate(context.Background(), textMessage(rejectedUserId, 1, testMagnetLink))
+	b.handleUpdate(context.Background(), callback(rejectedUserId, "id:movies"))
+
+	if len(b.server.calls) != 0 {
+		t.Errorf("got %d API calls for a rejected user, want 0", len(b.server.calls))
+	}
+	if len(b.pending) != 0 {
+		t.Errorf("got %d pending submissions for a rejected user, want 0", len(b.pending))
+	}
+}
+
+func TestSubmitMagnetLink(t *testing.T) {
+	b := newTestBot(t)
+
+	b.handleUpdate(context.Background(), textMessage(allowedUserId, 1, "please get "+testMagnetLink))
+
+	sent := b.server.sentMessages(t)
+	if len(sent) != 1 || sent[0].ChatId != testChatId || sent[0].ReplyToMessageId != 1 {
+		t.Fatalf("sent %+v, want a reply with the category keyboard", sent)
+	}
+	buttons := 0
+	for _, row := range sent[0].ReplyMarkup.InlineKeyboard {
+		buttons += len(row)
+	}
+	if buttons != len(categories) {
+		t.Errorf("keyboard has %d buttons, want one per category", buttons)
+	}
+
+	b.chooseCategory(t, models.Movies)
+
+	if len(b.transmission.magnetLinks) != 1 {
+		t.Fatalf("got %d magnet links, want 1", len(b.transmission.magnetLinks))
+	}
+	if got := b.transmission.magnetLinks[0]; got.MagnetLink != testMagnetLink || got.Category != models.Movies {
+		t.Errorf("added %+v, want the magnet link for movies", got)
+	}
+	if len(b.server.callsTo("answerCallbackQuery")) != 1 {
+		t.Error("callback query was not answered")
+	}
+	if text := b.editedText(t); text != "Submitted as movies" {
+		t.Errorf("keyboard message edited to %q", text)
+	}
+	if ref, ok := b.jobs[testTorrentHash]; !ok || ref.chatId != testChatId || ref.messageId != 1 {
+		t.Errorf("job reference = %+v, want the chat of the submission", ref)
+	}
+	if len(b.pending) != 0 {
+		t.Errorf("got %d pending submissions after choosing a category, want 0", len(b.pending))
+	}
+}
+
+func TestSubmitUrl(t *testing.T) {
+	tests := []struct {
+		text    string
+		url     string
+		urlType models.YoutubeUrlType
+	}{
+		{"https://www.youtube.com/watch?v=abc", "https://www.youtube.com/watch?v=abc", models.Video},
+		{"Album https://www.youtube.com/playlist?list=xyz", "https://www.youtube.com/playlist?list=xyz", models.Playlist},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			b := newTestBot(t)
+
+			b.handleUpdate(context.Background(), textMessage(allowedUserId, 1, test.text))
+			b.chooseCategory(t, models.Music)
+
+			if len(b.ytdlp.requests) != 1 {
+				t.Fatalf("got %d yt-dlp downloads, want 1", len(b.ytdlp.requests))
+			}
+			want := ytdlp.AddDownloadRequest{Url: test.url, UrlType: test.urlType, Category: models.Music}
+			if got := b.ytdlp.requests[0]; got != want {
+				t.Errorf("queued %+v, want %+v", got, want)
+			}
+			if _, ok := b.jobs["ytdlp-job"]; !ok {
+				t.Error("job of the download is not tracked")
+			}
+		})
+	}
+}
+
+func TestSubmitTorrentFile(t *testing.T) {
+	b := newTestBot(t)
+	content := []byte("d8:announce0:e")
+	b.server.files["documents/file-1"] = content
+
+	b.handleUpdate(context.Background(), documentMessage(1, "file-1", "Movie.TORRENT"))
+	b.chooseCategory(t, models.Series)
+
+	if len(b.transmission.torrentFiles) != 1 {
+		t.Fatalf("got %d torrent files, want 1", len(b.transmission.torrentFiles))
+	}
+	if got := b.transmission.torrentFiles[0]; !bytes.Equal(got.TorrentFileContent, content) || got.Category != models.Series {
+		t.Errorf("added %+v, want the downloaded file for series", got)
+	}
+}
+
+func TestRejectsTooLargeTorrentFile(t *testing.T) {
+	b := newTestBot(t)
+	b.server.files["documents/large"] = make([]byte, torrentFileMaxSize+1)
+	b.server.files["documents/limit"] = make([]byte, torrentFileMaxSize)
+
+	b.handleUpdate(context.Background(), documentMessage(1, "large", "large.torrent"))
+
+	sent := b.server.sentMessages(t)
+	if len(sent) != 1 || !strings.Contains(sent[0].Text, "exceeds") || sent[0].ReplyMarkup != nil {
+		t.Errorf("sent %+v, want an error reply", sent)
+	}
+	if len(b.pending) != 0 {
+		t.Errorf("got %d pending submissions for a too large file, want 0", len(b.pending))
+	}
+
+	b.handleUpdate(context.Background(), documentMessage(2, "limit", "limit.torrent"))
+
+	if len(b.pending) != 1 {
+		t.Errorf("got %d pending submissions for a file at the limit, want 1", len(b.pending))
+	}
+}
+
+func TestNothingToSubmit(t *testing.T) {
+	b := newTestBot(t)
+
+	b.handleUpdate(context.Background(), textMessage(allowedUserId, 1, "hello"))
+	b.handleUpdate(context.Background(), documentMessage(2, "file", "notes.txt"))
+
+	sent := b.server.sentMessages(t)
+	if len(sent) != 2 {
+		t.Fatalf("got %d replies, want 2", len(sent))
+	}
+	for _, s := range sent {
+		if !strings.HasPrefix(s.Text, "Send a magnet link") {
+			t.Errorf("replied %q, want the usage", s.Text)
+		}
+	}
+	if len(b.server.callsTo("getFile")) != 0 {
+		t.Error("document without .torrent extension was downloaded")
+	}
+}
+
+func TestCallbackErrors(t *testing.T) {
+	t.Run("expired", func(t *testing.T) {
+		b := newTestBot(t)
+
+		b.handleUpdate(context.Background(), callback(allowedUserId, "unknown:movies"))
+
+		answers := b.server.callsTo("answerCallbackQuery")
+		if len(answers) != 1 || !strings.Contains(string(answers[0]), "expired") {
+			t.Errorf("answered %s, want the submission to be expired", answers)
+		}
+		if len(b.server.callsTo("editMessageText")) != 0 {
+			t.Error("keyboard message was edited for an expired submission")
+		}
+	})
+	t.Run("invalid category", func(t *testing.T) {
+		b := newTestBot(t)
+		b.handleUpdate(context.Background(), textMessage(allowedUserId, 1, testMagnetLink))
+		id := ""
+		for k := range b.pending {
+			id = k
+		}
+
+		b.handleUpdate(context.Background(), callback(allowedUserId, id+callbackSeparator+"games"))
+
+		if len(b.transmission.magnetLinks) != 0 {
+			t.Error("magnet link was added with an invalid category")
+		}
+		if text := b.editedText(t); !strings.HasPrefix(text, "Submitting failed") {
+			t.Errorf("keyboard message edited to %q, want the error", text)
+		}
+	})
+	t.Run("submission fails", func(t *testing.T) {
+		b := newTestBot(t)
+		b.ytdlp.err = errors.New("queue is full")
+
+		b.handleUpdate(context.Background(), textMessage(allowedUserId, 1, "https://example.com/video"))
+		b.chooseCategory(t, models.Movies)
+
+		if text := b.editedText(t); text != "Submitting failed: queue is full" {
+			t.Errorf("keyboard message edited to %q, want the error", text)
+		}
+		if len(b.jobs) != 0 {
+			t.Errorf("got %d tracked jobs for a failed submission, want 0", len(b.jobs))
+		}
+	})
+}
+
+func TestJobReplies(t *testing.T) {
+	job := models.Job{
+		Id:       testTorrentHash,
+		Name:     "Movie",
+		Category: models.Movies,
+		Paused:   "low disk space",
+		Error:    "copy failed",
+		Result:   models.ImportResult{Imported: []string{"Movie.mkv", "Movie.srt"}},
+	}
+	tests := []struct {
+		eventType models.EventType
+		want      string
+		tracked   bool
+	}{
+		{models.SubmittedEvent, "", true},
+		{models.CompletedEvent, "", true},
+		{models.LowSpaceEvent, "Paused Movie: low disk space", true},
+		{models.ImportedEvent, "Imported Movie (2 file(s)) into movies", false},
+		{models.FailedEvent, "Failed Movie: copy failed", false},
+	}
+	for _, test := range tests {
+		t.Run(string(test.eventType), func(t *testing.T) {
+			b := newTestBot(t)
+			b.jobs[testTorrentHash] = chatReference{chatId: testChatId, messageId: 7, submitted: time.Now()}
+
+			b.Handle(context.Background(), events.Event{Type: test.eventType, Job: job})
+
+			sent := b.server.sentMessages(t)
+			if len(test.want) == 0 && len(sent) > 0 {
+				t.Errorf("sent %+v, want no reply", sent)
+			}
+			if len(test.want) > 0 && (len(sent) != 1 || sent[0].Text != test.want || sent[0].ChatId != testChatId || sent[0].ReplyToMessageId != 7) {
+				t.Errorf("sent %+v, want a reply %q to the submission", sent, test.want)
+			}
+			if _, ok := b.jobs[testTorrentHash]; ok != test.tracked {
+				t.Errorf("job tracked = %v after %s, want %v", ok, test.eventType, test.tracked)
+			}
+		})
+	}
+}
+
+func TestNoReplyForUntrackedJobs(t *testing.T) {
+	b := newTestBot(t)
+
+	b.Handle(context.Background(), events.Event{Type: models.ImportedEvent, Job: models.Job{Id: "other"}})
+
+	if len(b.server.calls) != 0 {
+		t.Errorf("got %d API calls for a job not submitted via Telegram, want 0", len(b.server.calls))
+	}
+}
+
+func TestPrune(t *testing.T) {
+	b := newTestBot(t)
+	now := time.Now()
+	b.pending["old"] = pendingSubmission{created: now.Add(-pendingSubmitMaxAge - time.Minute)}
+	b.pending["new"] = pendingSubmission{created: now.Add(-time.Minute)}
+	b.jobs["stalled"] = chatReference{submitted: now.Add(-jobReplyMaxAge - time.Minute)}
+	b.jobs["running"] = chatReference{submitted: now.Add(-time.Hour)}
+
+	b.prune(now)
+
+	if _, ok := b.pending["old"]; ok || len(b.pending) != 1 {
+		t.Errorf("pending = %v, want only the recent submission", b.pending)
+	}
+	if _, ok := b.jobs["stalled"]; ok || len(b.jobs) != 1 {
+		t.Errorf("jobs = %v, want only the recent job", b.jobs)
+	}
+}
+
+func TestStartPollsUpdates(t *testing.T) {
+	b := newTestBot(t)
+	b.server.updates = []update{
+		textMessage(rejectedUserId, 3, testMagnetLink),
+		textMessage(allowedUserId, 4, testMagnetLink),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.Start(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(b.server.callsTo("getUpdates")) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	polls := b.server.callsTo("getUpdates")
+	if len(polls) < 3 {
+		t.Fatalf("got %d polls, want at least 3", len(polls))
+	}
+	params := getUpdatesParams{}
+	json.Unmarshal(polls[len(polls)-1], &params)
+	if params.Offset != 5 {
+		t.Errorf("polled with offset %d, want the update after the last one", params.Offset)
+	}
+	sent := b.server.sentMessages(t)
+	if len(sent) != 1 || sent[0].ReplyToMessageId != 4 {
+		t.Errorf("sent %+v, want a single reply to the allowed user", sent)
+	}
+}
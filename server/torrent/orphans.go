@@ -0,0 +1,152 @@
+package torrent
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/config"
+)
+
+const (
+	defaultOrphanMinAge time.Duration = 24 * time.Hour
+	// Suffix of files Transmission is still downloading if incomplete files are renamed
+	partialFileSuffix string = ".part"
+)
+
+// OrphanScanner periodically looks for files in the download folder which do not belong to any torrent of Transmission
+type OrphanScanner interface {
+	Start(ctx context.Context)
+}
+
+type orphanScanner struct {
+	client       TransmissionClient
+	downloadPath string
+	config       config.OrphanConfig
+}
+
+func NewOrphanScanner(client TransmissionClient, pathConfig config.PathConfig, orphanConfig config.OrphanConfig) OrphanScanner {
+	if orphanConfig.MinAge == 0 {
+		orphanConfig.MinAge = defaultOrphanMinAge
+	}
+	return orphanScanner{
+		client:       client,
+		downloadPath: pathConfig.DownloadBasePath,
+		config:       orphanConfig,
+	}
+}
+
+// Start implements OrphanScanner.
+func (o orphanScanner) Start(ctx context.Context) {
+	if o.config.Interval == 0 || len(o.downloadPath) == 0 {
+		return
+	}
+	ticker := time.NewTicker(o.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Scanning for orphaned downloads stopped")
+			return
+		case <-ticker.C:
+			if err := o.scan(ctx); err != nil {
+				log.Printf("Scanning for orphaned downloads failed: %s", err)
+			}
+		}
+	}
+}
+
+// scan reports the files below the download folder which are not part of any torrent and deletes them unless running as dry run
+func (o orphanScanner) scan(ctx context.Context) error {
+	torrents, err := o.client.GetAllTorrents(ctx)
+	if err != nil {
+		return err
+	}
+	known := map[string]struct{}{}
+	for _, t := range torrents {
+		for _, name := range t.FileNames {
+			known[filepath.Clean(filepath.FromSlash(name))] = struct{}{}
+		}
+	}
+
+	orphans := []string{}
+	size := int64(0)
+	cutoff := time.Now().Add(-o.config.MinAge)
+	err = filepath.WalkDir(o.downloadPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(o.downloadPath, p)
+		if err != nil {
+			return err
+		}
+		if belongsToTorrent(known, rel) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// Recent files may belong to a torrent which has just been added
+		if info.ModTime().After(cutoff) {
+			return nil
+		}
+		orphans = append(orphans, rel)
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		return nil
+	}
+
+	action := "Found"
+	if o.config.Delete {
+		action = "Deleting"
+	}
+	log.Printf("%s %d orphaned file(s) (%s) in %s", action, len(orphans), config.ByteSize(size), o.downloadPath)
+	for _, orphan := range orphans {
+		log.Printf(" - %s", orphan)
+		if !o.config.Delete {
+			continue
+		}
+		if err := os.Remove(filepath.Join(o.downloadPath, orphan)); err != nil {
+			log.Printf("Deleting orphaned file %s failed: %s", orphan, err)
+			continue
+		}
+		o.removeEmptyDirs(filepath.Dir(orphan))
+	}
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents below the download folder as long as they are empty
+func (o orphanScanner) removeEmptyDirs(dir string) {
+	for ; dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		// Remove fails for folders which are not empty
+		if err := os.Remove(filepath.Join(o.downloadPath, dir)); err != nil {
+			return
+		}
+	}
+}
+
+// belongsToTorrent reports whether a file is one of the known torrent files, possibly incomplete or in a subfolder
+// of the download folder like the incomplete folder of Transmission
+func belongsToTorrent(known map[string]struct{}, rel string) bool {
+	rel = strings.TrimSuffix(rel, partialFileSuffix)
+	for {
+		if _, ok := known[rel]; ok {
+			return true
+		}
+		_, rest, found := strings.Cut(rel, string(filepath.Separator))
+		if !found {
+			return false
+		}
+		rel = rest
+	}
+}
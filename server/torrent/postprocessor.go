@@ -8,8 +8,9 @@ import (
 	"time"
 
 	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/diskspace"
 	"github.com/bongofriend/torrent-ingest/models"
-	cp "github.com/otiai10/copy"
+	"github.com/bongofriend/torrent-ingest/postprocess"
 )
 
 const (
@@ -23,14 +24,28 @@ type FinishedTorrentPostProcessor interface {
 type finishedTorrentPostProcessor struct {
 	client            TransmissionClient
 	pathConfig        config.PathConfig
+	categories        config.CategoriesConfig
+	cleanup           config.CleanupConfig
+	importer          postprocess.Importer
+	guard             diskspace.Guard
 	concurrentJobChan chan any
+	mu                *sync.Mutex
+	// handled contains the hashes of the torrents which are being imported or failed to import. Torrents are only removed
+	// from Transmission once imported, failed ones are kept to not lose their data and are not retried until restart.
+	handled map[string]struct{}
 }
 
-func NewFinishedTorrentProcessor(t TransmissionClient, d config.PathConfig) FinishedTorrentPostProcessor {
+func NewFinishedTorrentProcessor(t TransmissionClient, d config.PathConfig, c config.CategoriesConfig, cleanup config.CleanupConfig, i postprocess.Importer, g diskspace.Guard) FinishedTorrentPostProcessor {
 	return finishedTorrentPostProcessor{
 		client:            t,
 		pathConfig:        d,
+		categories:        c,
+		cleanup:           cleanup,
+		importer:          i,
+		guard:             g,
 		concurrentJobChan: make(chan any, concurrentJobLimit),
+		mu:                &sync.Mutex{},
+		handled:           map[string]struct{}{},
 	}
 }
 
@@ -61,13 +76,22 @@ func (f finishedTorrentPostProcessor) poll(ctx context.Context, interval time.Du
 			log.Println("Polling for finished torrents stopped")
 			return
 		case <-ticker.C:
+			if f.cleanup.Policy == config.DeleteAfterSeeding {
+				f.removeSeeded(ctx)
+			}
+			// Finished torrents stay in Transmission until there is enough space to import them
+			if err := f.guard.Check(); err != nil {
+				continue
+			}
 			torrents, err := f.client.GetAllFinishedTorrents(ctx)
 			if err != nil {
 				log.Println(err)
 				continue
 			}
 			for _, to := range torrents {
-				finishedTorrentsChan <- to
+				if f.markHandled(to) {
+					finishedTorrentsChan <- to
+				}
 			}
 		}
 	}
@@ -85,47 +109,78 @@ func (f finishedTorrentPostProcessor) handleFinishedTorrent(ctx context.Context,
 				defer func() {
 					<-f.concurrentJobChan
 				}()
-				if err := f.client.RemoveTorrent(ctx, t); err != nil {
-					log.Println(err)
-					return
+				job := newTorrentJob(t)
+				for _, fi := range t.FileNames {
+					job.Files = append(job.Files, models.JobFile{
+						Source: filepath.Join(f.pathConfig.DownloadBasePath, fi),
+						Target: fi,
+					})
 				}
-				var dest string
-				switch t.Category {
-				case models.Audiobook:
-					dest = f.pathConfig.Destinations.Audiobooks
-				case models.Anime:
-					dest = f.pathConfig.Destinations.Anime
-				case models.Series:
-					dest = f.pathConfig.Destinations.Series
-				case models.Movies:
-					dest = f.pathConfig.Destinations.Movie
-				case models.Music:
-					dest = f.pathConfig.Destinations.Music
-				default:
-					log.Printf("Unknown category %s for torrent %s", t.Category, t.Hash)
-					return
+				if f.categories.For(t.Category).Verify.Pieces {
+					if err := f.client.VerifyTorrent(ctx, t); err != nil {
+						// The torrent is kept while Transmission downloads the corrupt pieces again
+						f.importer.Fail(ctx, job, err)
+						return
+					}
 				}
-
-				if len(dest) == 0 {
-					log.Printf("No destination configured for category %s, skipping torrent %s", t.Category, t.Hash)
+				if err := f.importer.Import(ctx, job); err != nil {
+					log.Println(err)
 					return
 				}
-				if err := f.copy(t, dest); err != nil {
+				if err := f.cleanUp(ctx, t); err != nil {
 					log.Println(err)
 					return
 				}
+				f.unmarkHandled(t)
 			}()
 		}
 	}
 }
 
-func (f finishedTorrentPostProcessor) copy(t AddedTorrent, dest string) error {
-	for _, fi := range t.FileNames {
-		srcPath := filepath.Join(f.pathConfig.DownloadBasePath, fi)
-		destPath := filepath.Join(dest, fi)
-		if err := cp.Copy(srcPath, destPath); err != nil {
-			return err
+// cleanUp removes an imported torrent or its files according to the cleanup policy
+func (f finishedTorrentPostProcessor) cleanUp(ctx context.Context, t AddedTorrent) error {
+	switch f.cleanup.Policy {
+	case config.DeleteAfterImport:
+		return f.client.RemoveTorrent(ctx, t, true)
+	case config.DeleteAfterSeeding:
+		return f.client.MarkImported(ctx, t)
+	default:
+		return f.client.RemoveTorrent(ctx, t, false)
+	}
+}
+
+// removeSeeded removes imported torrents together with their files once they reached their seed limits
+func (f finishedTorrentPostProcessor) removeSeeded(ctx context.Context) {
+	torrents, err := f.client.GetAllTorrents(ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, t := range torrents {
+		if !t.Imported || !t.Seeded {
+			continue
 		}
+		if err := f.client.RemoveTorrent(ctx, t, true); err != nil {
+			log.Println(err)
+			continue
+		}
+		log.Printf("Removed seeded torrent %s with its files", t.Name)
 	}
-	return nil
+}
+
+// markHandled marks a torrent as handled and reports whether it has not been handled before
+func (f finishedTorrentPostProcessor) markHandled(t AddedTorrent) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.handled[t.Hash]; ok {
+		return false
+	}
+	f.handled[t.Hash] = struct{}{}
+	return true
+}
+
+func (f finishedTorrentPostProcessor) unmarkHandled(t AddedTorrent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.handled, t.Hash)
 }
@@ -6,26 +6,44 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/diskspace"
+	"github.com/bongofriend/torrent-ingest/events"
 	"github.com/bongofriend/torrent-ingest/models"
 	"github.com/hekmon/transmissionrpc/v3"
 )
 
 const (
 	categoryLabelPrefix string = "Category"
+	importedLabel       string = "Imported"
+	// verifyPollInterval is the interval the state of a torrent is read while Transmission rechecks it
+	verifyPollInterval time.Duration = 2 * time.Second
+	// localError is the error code of Transmission for local errors, e.g. missing files. Tracker warnings and errors
+	// do not affect the data of a torrent.
+	localError int64 = 3
 )
 
 var (
 	errCategoryNotFound error = errors.New("category not found in torrent labels")
+	errTorrentNotFound  error = errors.New("torrent not found")
+	verifyFields              = []string{"status", "leftUntilDone", "error", "errorString"}
 )
 
 type AddedTorrent struct {
 	Id        int64
 	Hash      string
+	Name      string
 	FileNames []string
 	Category  models.MediaCategory
+	Labels    []string
+	// Imported is set for torrents which are kept seeding after they have been imported
+	Imported bool
+	// Seeded is set once a torrent reached its seed limits
+	Seeded bool
 }
 
 type AddMagnetLinkRequest struct {
@@ -42,14 +60,24 @@ type TransmissionClient interface {
 	AddMagnetLink(ctx context.Context, req AddMagnetLinkRequest) (AddedTorrent, error)
 	AddTorrentFile(ctx context.Context, req AddTorrentFileRequest) (AddedTorrent, error)
 	GetAllFinishedTorrents(ctx context.Context) ([]AddedTorrent, error)
-	RemoveTorrent(ctx context.Context, torrent AddedTorrent) error
+	// GetAllTorrents returns every torrent of Transmission including the ones without category and unfinished ones
+	GetAllTorrents(ctx context.Context) ([]AddedTorrent, error)
+	// RemoveTorrent removes a torrent from Transmission, deleteData deletes its downloaded files as well
+	RemoveTorrent(ctx context.Context, torrent AddedTorrent, deleteData bool) error
+	// MarkImported labels a torrent as imported so it is not imported again while it keeps seeding
+	MarkImported(ctx context.Context, torrent AddedTorrent) error
+	// VerifyTorrent rechecks the downloaded pieces of a torrent against its info dict and waits for the result.
+	// Torrents with missing or corrupt pieces are started again to download them.
+	VerifyTorrent(ctx context.Context, torrent AddedTorrent) error
 }
 
 type transmissionClient struct {
-	client *transmissionrpc.Client
+	client    *transmissionrpc.Client
+	publisher events.Publisher
+	guard     diskspace.Guard
 }
 
-func NewTransmissionClient(transmissionConfig config.TransmissionConfig) (TransmissionClient, error) {
+func NewTransmissionClient(transmissionConfig config.TransmissionConfig, publisher events.Publisher, guard diskspace.Guard) (TransmissionClient, error) {
 	transmissionUrl, err := url.Parse(transmissionConfig.Url)
 	if err != nil {
 		return nil, err
@@ -60,7 +88,9 @@ func NewTransmissionClient(transmissionConfig config.TransmissionConfig) (Transm
 		return nil, err
 	}
 	return transmissionClient{
-		client: client,
+		client:    client,
+		publisher: publisher,
+		guard:     guard,
 	}, err
 }
 
@@ -71,29 +101,47 @@ func (t transmissionClient) GetAllFinishedTorrents(ctx context.Context) ([]Added
 		return nil, err
 	}
 	torrents := []AddedTorrent{}
-	for _, t := range allTorrents {
-		if t.MagnetLink == nil || t.Files == nil || (t.PercentDone != nil && *t.PercentDone < 1.0) {
+	for _, to := range allTorrents {
+		if to.MagnetLink == nil || to.Files == nil || (to.PercentDone != nil && *to.PercentDone < 1.0) {
 			continue
 		}
-		category, err := decodeCategoryFromLabels(t.Labels)
-		if err != nil {
+		added := toAddedTorrent(to)
+		if len(added.Category) == 0 || added.Imported {
 			continue
 		}
-		filenames := make([]string, len(t.Files))
-		for j, f := range t.Files {
-			filenames[j] = f.Name
-		}
-		torrents = append(torrents, AddedTorrent{
-			Id:        *t.ID,
-			Hash:      *t.HashString,
-			FileNames: filenames,
-			Category:  models.MediaCategory(category),
-		})
+		torrents = append(torrents, added)
+	}
+	return torrents, nil
+}
+
+// GetAllTorrents implements TransmissionClient.
+func (t transmissionClient) GetAllTorrents(ctx context.Context) ([]AddedTorrent, error) {
+	allTorrents, err := t.client.TorrentGetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	torrents := make([]AddedTorrent, len(allTorrents))
+	for i, to := range allTorrents {
+		torrents[i] = toAddedTorrent(to)
 	}
 	return torrents, nil
 }
 
+// MarkImported implements TransmissionClient.
+func (t transmissionClient) MarkImported(ctx context.Context, torrent AddedTorrent) error {
+	if slices.Contains(torrent.Labels, importedLabel) {
+		return nil
+	}
+	return t.client.TorrentSet(ctx, transmissionrpc.TorrentSetPayload{
+		IDs:    []int64{torrent.Id},
+		Labels: append(slices.Clone(torrent.Labels), importedLabel),
+	})
+}
+
 func (t transmissionClient) AddMagnetLink(context context.Context, request AddMagnetLinkRequest) (AddedTorrent, error) {
+	if err := t.guard.Check(); err != nil {
+		return AddedTorrent{}, err
+	}
 	payload := transmissionrpc.TorrentAddPayload{
 		Filename: &request.MagnetLink,
 		Labels:   []string{encodeCatgeoryAsLabel(request.Category)},
@@ -102,22 +150,66 @@ func (t transmissionClient) AddMagnetLink(context context.Context, request AddMa
 	if err != nil {
 		return AddedTorrent{}, err
 	}
-	return AddedTorrent{
+	added := AddedTorrent{
 		Id:        *to.ID,
 		Hash:      *to.HashString,
+		Name:      getTorrentName(to),
 		FileNames: getFileNamesFromTorrent(to),
 		Category:  request.Category,
-	}, err
+	}
+	t.publisher.Publish(models.SubmittedEvent, newTorrentJob(added))
+	return added, err
 }
 
-func (t transmissionClient) RemoveTorrent(ctx context.Context, torrent AddedTorrent) error {
+func (t transmissionClient) RemoveTorrent(ctx context.Context, torrent AddedTorrent, deleteData bool) error {
 	return t.client.TorrentRemove(ctx, transmissionrpc.TorrentRemovePayload{
 		IDs:             []int64{torrent.Id},
-		DeleteLocalData: false,
+		DeleteLocalData: deleteData,
 	})
 }
 
+// VerifyTorrent implements TransmissionClient.
+func (t transmissionClient) VerifyTorrent(ctx context.Context, torrent AddedTorrent) error {
+	if err := t.client.TorrentVerifyIDs(ctx, []int64{torrent.Id}); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(verifyPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		torrents, err := t.client.TorrentGet(ctx, verifyFields, []int64{torrent.Id})
+		if err != nil {
+			return err
+		}
+		if len(torrents) == 0 {
+			return errTorrentNotFound
+		}
+		to := torrents[0]
+		if to.Status == nil || *to.Status == transmissionrpc.TorrentStatusCheckWait || *to.Status == transmissionrpc.TorrentStatusCheck {
+			continue
+		}
+		if to.Error != nil && *to.Error == localError && to.ErrorString != nil {
+			return fmt.Errorf("verifying %s failed: %s", torrent.Name, *to.ErrorString)
+		}
+		if to.LeftUntilDone != nil && *to.LeftUntilDone > 0 {
+			// Torrents which were stopped, e.g. after reaching their seed limits, stay stopped after the check
+			if err := t.client.TorrentStartIDs(ctx, []int64{torrent.Id}); err != nil {
+				return fmt.Errorf("starting %s to download %d missing or corrupt bytes failed: %w", torrent.Name, *to.LeftUntilDone, err)
+			}
+			return fmt.Errorf("verifying %s failed: %d bytes are missing or corrupt", torrent.Name, *to.LeftUntilDone)
+		}
+		return nil
+	}
+}
+
 func (t transmissionClient) AddTorrentFile(ctx context.Context, request AddTorrentFileRequest) (AddedTorrent, error) {
+	if err := t.guard.Check(); err != nil {
+		return AddedTorrent{}, err
+	}
 	encodedFile := base64.StdEncoding.EncodeToString(request.TorrentFileContent)
 	payload := transmissionrpc.TorrentAddPayload{
 		Labels:   []string{encodeCatgeoryAsLabel(request.Category)},
@@ -127,12 +219,22 @@ func (t transmissionClient) AddTorrentFile(ctx context.Context, request AddTorre
 	if err != nil {
 		return AddedTorrent{}, err
 	}
-	return AddedTorrent{
+	added := AddedTorrent{
 		Id:        *to.ID,
 		Hash:      *to.HashString,
+		Name:      getTorrentName(to),
 		FileNames: getFileNamesFromTorrent(to),
 		Category:  request.Category,
-	}, nil
+	}
+	t.publisher.Publish(models.SubmittedEvent, newTorrentJob(added))
+	return added, nil
+}
+
+// newTorrentJob creates the job of a torrent, identified by the torrent hash so events of the same torrent can be correlated
+func newTorrentJob(t AddedTorrent) *models.Job {
+	job := models.NewJob(models.TorrentJob, t.Name, t.Category)
+	job.Id = t.Hash
+	return job
 }
 
 func encodeCatgeoryAsLabel(category models.MediaCategory) string {
@@ -148,6 +250,20 @@ func decodeCategoryFromLabels(labels []string) (string, error) {
 	return "", errCategoryNotFound
 }
 
+func toAddedTorrent(to transmissionrpc.Torrent) AddedTorrent {
+	category, _ := decodeCategoryFromLabels(to.Labels)
+	return AddedTorrent{
+		Id:        *to.ID,
+		Hash:      *to.HashString,
+		Name:      getTorrentName(to),
+		FileNames: getFileNamesFromTorrent(to),
+		Category:  models.MediaCategory(category),
+		Labels:    to.Labels,
+		Imported:  slices.Contains(to.Labels, importedLabel),
+		Seeded:    to.IsFinished != nil && *to.IsFinished,
+	}
+}
+
 func getFileNamesFromTorrent(to transmissionrpc.Torrent) []string {
 	filenames := make([]string, len(to.Files))
 	for j, f := range to.Files {
@@ -155,3 +271,10 @@ func getFileNamesFromTorrent(to transmissionrpc.Torrent) []string {
 	}
 	return filenames
 }
+
+func getTorrentName(to transmissionrpc.Torrent) string {
+	if to.Name == nil {
+		return *to.HashString
+	}
+	return *to.Name
+}
@@ -0,0 +1,309 @@
+package watch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/diskspace"
+	"github.com/bongofriend/torrent-ingest/models"
+	"github.com/bongofriend/torrent-ingest/torrent"
+	"github.com/bongofriend/torrent-ingest/ytdlp"
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	defaultPollingInterval time.Duration = 30 * time.Second
+	// settleDelay is waited after a change before scanning, files modified within minFileAge may still be written and are skipped
+	settleDelay   time.Duration = 2 * time.Second
+	minFileAge    time.Duration = time.Second
+	doneDirName   string        = ".done"
+	failedDirName string        = ".failed"
+)
+
+var (
+	errNothingToSubmit = errors.New("no magnet link or URL found")
+
+	categories        = []models.MediaCategory{models.Movies, models.Series, models.Anime, models.Music, models.Audiobook}
+	magnetLinkPattern = regexp.MustCompile(`magnet:\?\S+`)
+	urlPattern        = regexp.MustCompile(`https?://\S+`)
+	// Text files are read for magnet links and URLs, .url files are internet shortcuts with a "URL=" line
+	textExtensions = map[string]struct{}{".magnet": {}, ".txt": {}, ".url": {}}
+)
+
+// Watcher submits the files dropped into the watch folders of the categories
+type Watcher interface {
+	Start(ctx context.Context)
+}
+
+type watcher struct {
+	config               config.WatchConfig
+	transmissionClient   torrent.TransmissionClient
+	ytdlpDownloadService ytdlp.YtdlpDownloadService
+}
+
+func NewWatcher(watchConfig config.WatchConfig, transmissionClient torrent.TransmissionClient, ytdlpDownloadService ytdlp.YtdlpDownloadService) Watcher {
+	if watchConfig.PollingInterval == 0 {
+		watchConfig.PollingInterval = defaultPollingInterval
+	}
+	return watcher{
+		config:               watchConfig,
+		transmissionClient:   transmissionClient,
+		ytdlpDownloadService: ytdlpDownloadService,
+	}
+}
+
+// Start implements Watcher.
+func (w watcher) Start(ctx context.Context) {
+	if len(w.config.Path) == 0 {
+		return
+	}
+	for _, c := range categories {
+		for _, dir := range []string{doneDirName, failedDirName} {
+			if err := os.MkdirAll(filepath.Join(w.config.Path, string(c), dir), 0755); err != nil {
+				log.Printf("Creating watch folder for %s failed: %s", c, err)
+			}
+		}
+	}
+	events := w.notifications(ctx)
+	ticker := time.NewTicker(w.config.PollingInterval)
+	defer ticker.Stop()
+	settle := time.NewTimer(0)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Watching folders stopped")
+			return
+		case <-events:
+			// Changes are collected until the folder has settled
+			settle.Reset(settleDelay)
+		case <-settle.C:
+			w.scan(ctx)
+		case <-ticker.C:
+			w.scan(ctx)
+		}
+	}
+}
+
+// notifications watches the category folders with inotify. Nothing is sent if inotify is not available or polling is configured.
+func (w watcher) notifications(ctx context.Context) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	if w.config.Polling {
+		return changed
+	}
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Watching folders with inotify failed, polling every %s: %s", w.config.PollingInterval, err)
+		return changed
+	}
+	for _, c := range categories {
+		if err := fsWatcher.Add(filepath.Join(w.config.Path, string(c))); err != nil {
+			log.Printf("Watching folder of %s with inotify failed, polling every %s: %s", c, w.config.PollingInterval, err)
+		}
+	}
+	go func() {
+		defer fsWatcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-fsWatcher.Errors:
+				log.Printf("Watching folders failed: %s", err)
+			case e := <-fsWatcher.Events:
+				if !e.Has(fsnotify.Create) && !e.Has(fsnotify.Write) && !e.Has(fsnotify.Rename) {
+					continue
+				}
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changed
+}
+
+// scan submits the files in the watch folders which have not been modified for a moment
+func (w watcher) scan(ctx context.Context) {
+	for _, c := range categories {
+		dir := filepath.Join(w.config.Path, string(c))
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			log.Printf("Reading watch folder %s failed: %s", dir, err)
+			continue
+		}
+		for _, e := range entries {
+			if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil || time.Since(info.ModTime()) < minFileAge {
+				continue
+			}
+			w.process(ctx, c, filepath.Join(dir, e.Name()))
+		}
+	}
+}
+
+// failedLine is a line of a text file whose download could not be submitted
+type failedLine struct {
+	line string
+	err  error
+}
+
+// process submits a file and moves it into the .done or .failed folder of its category. Text files are moved into .done
+// if any of their downloads was submitted, the lines which failed are written to a file of the same name in .failed.
+// Lines which failed for low disk space are left in the watch folder to be submitted again.
+func (w watcher) process(ctx context.Context, category models.MediaCategory, file string) {
+	ext := strings.ToLower(filepath.Ext(file))
+	_, isText := textExtensions[ext]
+	if ext != ".torrent" && !isText {
+		return
+	}
+	name := filepath.Base(file)
+	content, err := os.ReadFile(file)
+	if err != nil {
+		log.Printf("Reading %s from watch folder failed: %s", name, err)
+		w.move(file, failedDirName)
+		return
+	}
+	if !isText {
+		_, err := w.transmissionClient.AddTorrentFile(ctx, torrent.AddTorrentFileRequest{
+			Category:           category,
+			TorrentFileContent: content,
+		})
+		switch {
+		case errors.Is(err, diskspace.ErrLowSpace):
+			// The file is submitted again once there is enough space
+		case err != nil:
+			log.Printf("Submitting %s from watch folder failed: %s", name, err)
+			w.move(file, failedDirName)
+		default:
+			log.Printf("Submitted %s from watch folder for %s", name, category)
+			w.move(file, doneDirName)
+		}
+		return
+	}
+
+	submitted, failed := w.submitLines(ctx, category, content)
+	if submitted == 0 && len(failed) == 0 {
+		log.Printf("Submitting %s from watch folder failed: %s", name, errNothingToSubmit)
+		w.move(file, failedDirName)
+		return
+	}
+	lowSpace := []failedLine{}
+	rejected := []failedLine{}
+	for _, f := range failed {
+		if errors.Is(f.err, diskspace.ErrLowSpace) {
+			lowSpace = append(lowSpace, f)
+		} else {
+			rejected = append(rejected, f)
+		}
+	}
+	if submitted == 0 && len(rejected) == 0 {
+		// The file is submitted again once there is enough space
+		return
+	}
+	for _, f := range failed {
+		log.Printf("Submitting %q of %s from watch folder failed: %s", f.line, name, f.err)
+	}
+	dirName := failedDirName
+	if submitted > 0 {
+		log.Printf("Submitted %d download(s) of %s from watch folder for %s", submitted, name, category)
+		dirName = doneDirName
+	}
+	if !w.move(file, dirName) {
+		return
+	}
+	dir := filepath.Dir(file)
+	// The file in .failed contains all lines if nothing was submitted
+	if submitted > 0 {
+		w.writeLines(filepath.Join(dir, failedDirName), name, rejected)
+	}
+	w.writeLines(dir, name, lowSpace)
+}
+
+// move moves a file of a watch folder into its .done or .failed folder and reports whether it has been moved
+func (w watcher) move(file string, dirName string) bool {
+	if err := os.Rename(file, freeName(filepath.Join(filepath.Dir(file), dirName), filepath.Base(file))); err != nil {
+		log.Printf("Moving %s out of the watch folder failed: %s", filepath.Base(file), err)
+		return false
+	}
+	return true
+}
+
+// writeLines writes the lines which failed to a file in dir. The lines can be submitted again by moving the file into
+// the watch folder, the errors are written as comments which are skipped.
+func (w watcher) writeLines(dir string, name string, failed []failedLine) {
+	if len(failed) == 0 {
+		return
+	}
+	var lines bytes.Buffer
+	for _, f := range failed {
+		fmt.Fprintf(&lines, "# %s\n%s\n", strings.ReplaceAll(f.err.Error(), "\n", " "), f.line)
+	}
+	if err := os.WriteFile(freeName(dir, name), lines.Bytes(), 0644); err != nil {
+		log.Printf("Writing failed lines of %s failed: %s", name, err)
+	}
+}
+
+// freeName returns the path of the first name like "links (2).txt" which does not exist in dir yet
+func freeName(dir string, name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := filepath.Join(dir, name)
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(candidate); err != nil {
+			return candidate
+		}
+		candidate = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, n, ext))
+	}
+}
+
+// submitLines hands the magnet links and URLs of a text file over and returns how many downloads were submitted and the lines which failed
+func (w watcher) submitLines(ctx context.Context, category models.MediaCategory, content []byte) (int, []failedLine) {
+	submitted := 0
+	failed := []failedLine{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		var err error
+		switch {
+		case magnetLinkPattern.MatchString(line):
+			_, err = w.transmissionClient.AddMagnetLink(ctx, torrent.AddMagnetLinkRequest{
+				Category:   category,
+				MagnetLink: magnetLinkPattern.FindString(line),
+			})
+		case urlPattern.MatchString(line):
+			rawUrl := urlPattern.FindString(line)
+			_, err = w.ytdlpDownloadService.QueueDownload(ctx, ytdlp.AddDownloadRequest{
+				Url:      rawUrl,
+				UrlType:  ytdlp.UrlTypeOf(rawUrl),
+				Category: category,
+			})
+		default:
+			continue
+		}
+		if err != nil {
+			failed = append(failed, failedLine{line: line, err: err})
+			continue
+		}
+		submitted++
+	}
+	if err := scanner.Err(); err != nil {
+		failed = append(failed, failedLine{err: fmt.Errorf("reading remaining lines: %w", err)})
+	}
+	return submitted, failed
+}
@@ -0,0 +1,223 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/diskspace"
+	"github.com/bongofriend/torrent-ingest/models"
+	"github.com/bongofriend/torrent-ingest/torrent"
+	"github.com/bongofriend/torrent-ingest/ytdlp"
+)
+
+// fakeTransmissionClient fails the magnet links and torrent files containing a key of errs
+type fakeTransmissionClient struct {
+	torrent.TransmissionClient
+	errs        map[string]error
+	magnetLinks []string
+}
+
+func (f *fakeTransmissionClient) errorFor(value string) error {
+	for k, err := range f.errs {
+		if strings.Contains(value, k) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeTransmissionClient) AddMagnetLink(ctx context.Context, req torrent.AddMagnetLinkRequest) (torrent.AddedTorrent, error) {
+	if err := f.errorFor(req.MagnetLink); err != nil {
+		return torrent.AddedTorrent{}, err
+	}
+	f.magnetLinks = append(f.magnetLinks, req.MagnetLink)
+	return torrent.AddedTorrent{}, nil
+}
+
+func (f *fakeTransmissionClient) AddTorrentFile(ctx context.Context, req torrent.AddTorrentFileRequest) (torrent.AddedTorrent, error) {
+	return torrent.AddedTorrent{}, f.errorFor(string(req.TorrentFileContent))
+}
+
+type fakeYtdlpService struct {
+	err  error
+	urls []string
+}
+
+func (f *fakeYtdlpService) QueueDownload(ctx context.Context, request ytdlp.AddDownloadRequest) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	f.urls = append(f.urls, request.Url)
+	return "job", nil
+}
+
+func TestProcess(t *testing.T) {
+	errRejected := errors.New("rejected")
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		errs     map[string]error
+		ytdlpErr error
+		// where the file is found afterwards, "" if it stays in the watch folder
+		moved  string
+		failed []string
+		// lines left in the watch folder to be submitted again
+		pending   []string
+		submitted int
+	}{
+		{
+			name:      "links",
+			file:      "links.txt",
+			content:   "# comment\nmagnet:?xt=urn:btih:a\n\nhttps://example.com/video\n",
+			moved:     doneDirName,
+			submitted: 2,
+		},
+		{
+			name:      "partially submitted",
+			file:      "links.txt",
+			content:   "magnet:?xt=urn:btih:a\nmagnet:?xt=urn:btih:b\nhttps://example.com/video\n",
+			errs:      map[string]error{"btih:b": diskspace.ErrLowSpace},
+			ytdlpErr:  errRejected,
+			moved:     doneDirName,
+			failed:    []string{"https://example.com/video"},
+			pending:   []string{"magnet:?xt=urn:btih:b"},
+			submitted: 1,
+		},
+		{
+			name:    "low space",
+			file:    "links.magnet",
+			content: "magnet:?xt=urn:btih:a\nmagnet:?xt=urn:btih:b\n",
+			errs:    map[string]error{"btih": diskspace.ErrLowSpace},
+		},
+		{
+			name:    "low space and rejected",
+			file:    "links.txt",
+			content: "magnet:?xt=urn:btih:a\nmagnet:?xt=urn:btih:b\n",
+			errs:    map[string]error{"btih:a": diskspace.ErrLowSpace, "btih:b": errRejected},
+			moved:   failedDirName,
+			pending: []string{"magnet:?xt=urn:btih:a"},
+		},
+		{
+			name:    "nothing to submit",
+			file:    "notes.txt",
+			content: "hello\n",
+			moved:   failedDirName,
+		},
+		{
+			name:    "torrent file",
+			file:    "movie.torrent",
+			content: "d8:announce0:e",
+			moved:   doneDirName,
+		},
+		{
+			name:    "torrent file with low space",
+			file:    "movie.torrent",
+			content: "d8:announce0:e",
+			errs:    map[string]error{"announce": diskspace.ErrLowSpace},
+		},
+		{
+			name:    "rejected torrent file",
+			file:    "movie.torrent",
+			content: "d8:announce0:e",
+			errs:    map[string]error{"announce": errRejected},
+			moved:   failedDirName,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(root, string(models.Movies))
+			for _, d := range []string{doneDirName, failedDirName} {
+				if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			file := filepath.Join(dir, test.file)
+			if err := os.WriteFile(file, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			transmission := &fakeTransmissionClient{errs: test.errs}
+			ytdlpService := &fakeYtdlpService{err: test.ytdlpErr}
+			w := NewWatcher(config.WatchConfig{Path: root}, transmission, ytdlpService).(watcher)
+
+			w.process(context.Background(), models.Movies, file)
+
+			location := dir
+			if len(test.moved) > 0 {
+				location = filepath.Join(dir, test.moved)
+			}
+			content, err := os.ReadFile(filepath.Join(location, test.file))
+			if err != nil || string(content) != test.content {
+				t.Errorf("file not found unchanged in %s: %s", location, err)
+			}
+			if submitted := len(transmission.magnetLinks) + len(ytdlpService.urls); submitted != test.submitted {
+				t.Errorf("submitted %d download(s), want %d", submitted, test.submitted)
+			}
+			if len(test.moved) > 0 {
+				if lines := readLines(t, filepath.Join(dir, test.file)); !slices.Equal(lines, test.pending) {
+					t.Errorf("pending lines = %q, want %q", lines, test.pending)
+				}
+			}
+			if test.moved != doneDirName || strings.HasSuffix(test.file, ".torrent") {
+				return
+			}
+			// The lines which failed are written to .failed
+			if lines := readLines(t, filepath.Join(dir, failedDirName, test.file)); !slices.Equal(lines, test.failed) {
+				t.Errorf("failed lines = %q, want %q", lines, test.failed)
+			}
+		})
+	}
+}
+
+func TestProcessKeepsExistingFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), string(models.Movies))
+	for _, name := range []string{"links.txt", filepath.Join(doneDirName, "links.txt"), filepath.Join(failedDirName, "links.txt")} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("magnet:?xt=urn:btih:a\nmagnet:?xt=urn:btih:b\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	transmission := &fakeTransmissionClient{errs: map[string]error{"btih:b": errors.New("rejected")}}
+	w := NewWatcher(config.WatchConfig{Path: filepath.Dir(dir)}, transmission, &fakeYtdlpService{}).(watcher)
+
+	w.process(context.Background(), models.Movies, filepath.Join(dir, "links.txt"))
+
+	for _, d := range []string{doneDirName, failedDirName} {
+		if lines := readLines(t, filepath.Join(dir, d, "links.txt")); len(lines) != 2 {
+			t.Errorf("existing file in %s was overwritten: %q", d, lines)
+		}
+	}
+	if lines := readLines(t, filepath.Join(dir, doneDirName, "links (2).txt")); len(lines) != 2 {
+		t.Errorf("processed file not found in %s: %q", doneDirName, lines)
+	}
+	if lines := readLines(t, filepath.Join(dir, failedDirName, "links (2).txt")); !slices.Equal(lines, []string{"magnet:?xt=urn:btih:b"}) {
+		t.Errorf("failed lines = %q, want the rejected link", lines)
+	}
+}
+
+// readLines returns the lines of a file which are not comments, nil if the file does not exist
+func readLines(t *testing.T, name string) []string {
+	content, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{}
+	for _, l := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		if !strings.HasPrefix(l, "#") {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
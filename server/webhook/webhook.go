@@ -0,0 +1,205 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/events"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+const (
+	signatureHeader string = "X-Torrent-Ingest-Signature"
+	eventHeader     string = "X-Torrent-Ingest-Event"
+	deliveryHeader  string = "X-Torrent-Ingest-Delivery"
+
+	defaultMaxAttempts int           = 5
+	defaultTimeout     time.Duration = 10 * time.Second
+	initialBackoff     time.Duration = 1 * time.Second
+	maxBackoff         time.Duration = 5 * time.Minute
+)
+
+var (
+	errPermanent = errors.New("webhook rejected event")
+	errQueueFull = errors.New("event queue full")
+)
+
+type deadLetter struct {
+	Time  time.Time        `json:"time"`
+	Url   string           `json:"url"`
+	Event models.EventType `json:"event"`
+	Error string           `json:"error"`
+	Body  json.RawMessage  `json:"body"`
+}
+
+// deadLetterLog appends undeliverable events to a JSON lines file shared by all endpoints
+type deadLetterLog struct {
+	mu   *sync.Mutex
+	path string
+}
+
+func (d deadLetterLog) write(entry deadLetter) {
+	log.Printf("Giving up delivering %s event to webhook %s: %s", entry.Event, entry.Url, entry.Error)
+	if len(d.path) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	file, err := os.OpenFile(d.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer file.Close()
+	if err := json.NewEncoder(file).Encode(entry); err != nil {
+		log.Println(err)
+	}
+}
+
+type webhookListener struct {
+	endpoint    config.WebhookEndpointConfig
+	client      *http.Client
+	maxAttempts int
+	// backoff is the delay before the first retry, it doubles with every further attempt
+	backoff     time.Duration
+	deadLetters deadLetterLog
+}
+
+// NewListeners creates an event listener for every configured webhook endpoint
+func NewListeners(webhooksConfig config.WebhooksConfig) []events.Listener {
+	maxAttempts := webhooksConfig.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	deadLetters := deadLetterLog{
+		mu:   &sync.Mutex{},
+		path: webhooksConfig.DeadLetterFile,
+	}
+	listeners := make([]events.Listener, len(webhooksConfig.Endpoints))
+	for i, e := range webhooksConfig.Endpoints {
+		timeout := e.Timeout
+		if timeout == 0 {
+			timeout = defaultTimeout
+		}
+		listeners[i] = webhookListener{
+			endpoint:    e,
+			client:      &http.Client{Timeout: timeout},
+			maxAttempts: maxAttempts,
+			backoff:     initialBackoff,
+			deadLetters: deadLetters,
+		}
+	}
+	return listeners
+}
+
+// Handle implements events.Listener.
+func (w webhookListener) Handle(ctx context.Context, event events.Event) {
+	if !w.accepts(event) {
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		err = w.deliver(ctx, event, body)
+		if err == nil {
+			return
+		}
+		if errors.Is(err, errPermanent) || attempt >= w.maxAttempts {
+			break
+		}
+		log.Printf("Delivering %s event to webhook %s failed (attempt %d/%d), retrying in %s: %s", event.Type, w.endpoint.Url, attempt, w.maxAttempts, backoff, err)
+		if err = wait(ctx, backoff); err != nil {
+			break
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+	w.writeDeadLetter(event, body, err)
+}
+
+// Drop implements events.Dropper. Events dropped while deliveries are retried are written to the dead letter file.
+func (w webhookListener) Drop(event events.Event) {
+	if !w.accepts(event) {
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	w.writeDeadLetter(event, body, errQueueFull)
+}
+
+func (w webhookListener) writeDeadLetter(event events.Event, body []byte, err error) {
+	w.deadLetters.write(deadLetter{
+		Time:  time.Now(),
+		Url:   w.endpoint.Url,
+		Event: event.Type,
+		Error: err.Error(),
+		Body:  body,
+	})
+}
+
+func (w webhookListener) accepts(event events.Event) bool {
+	return len(w.endpoint.Events) == 0 || slices.Contains(w.endpoint.Events, event.Type)
+}
+
+func (w webhookListener) deliver(ctx context.Context, event events.Event, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.endpoint.Url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(eventHeader, string(event.Type))
+	req.Header.Set(deliveryHeader, fmt.Sprintf("%s-%s-%d", event.Job.Id, event.Type, event.Time.UnixNano()))
+	req.Header.Set(signatureHeader, "sha256="+sign(w.endpoint.Secret, body))
+
+	rsp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+	switch {
+	case rsp.StatusCode >= 200 && rsp.StatusCode < 300:
+		return nil
+	case rsp.StatusCode == http.StatusTooManyRequests || rsp.StatusCode >= 500:
+		return fmt.Errorf("unexpected status %s", rsp.Status)
+	default:
+		return fmt.Errorf("%w with status %s", errPermanent, rsp.Status)
+	}
+}
+
+func wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// sign returns the hex encoded HMAC-SHA256 of the body
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
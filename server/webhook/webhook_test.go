@@ -0,0 +1,214 @@
+package webhook
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/events"
+	"github.com/bongofriend/torrent-ingest/models"
+)
+
+const testBackoff time.Duration = 20 * time.Millisecond
+
+type request struct {
+	time   time.Time
+	header http.Header
+	body   []byte
+}
+
+// recordingServer answers with the given status codes in order, the last one is repeated
+func recordingServer(t *testing.T, statuses ...int) (*httptest.Server, func() []request) {
+	mu := &sync.Mutex{}
+	requests := []request{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, request{time: time.Now(), header: r.Header.Clone(), body: body})
+		status := statuses[min(len(requests), len(statuses))-1]
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []request {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func newTestListener(t *testing.T, endpoint config.WebhookEndpointConfig, maxAttempts int, deadLetterFile string) webhookListener {
+	listeners := NewListeners(config.WebhooksConfig{
+		Endpoints:      []config.WebhookEndpointConfig{endpoint},
+		MaxAttempts:    maxAttempts,
+		DeadLetterFile: deadLetterFile,
+	})
+	listener := listeners[0].(webhookListener)
+	listener.backoff = testBackoff
+	return listener
+}
+
+func testEvent() events.Event {
+	return events.Event{
+		Type: models.ImportedEvent,
+		Time: time.Now(),
+		Job:  models.Job{Id: "job-1", Name: "Movie"},
+	}
+}
+
+func readDeadLetters(t *testing.T, path string) []deadLetter {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	entries := []deadLetter{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := deadLetter{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("dead letter line %q is not valid JSON: %s", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestHandleSignsBody(t *testing.T) {
+	server, requests := recordingServer(t, http.StatusOK)
+	listener := newTestListener(t, config.WebhookEndpointConfig{Url: server.URL, Secret: "s3cret"}, 0, "")
+	event := testEvent()
+
+	listener.Handle(context.Background(), event)
+
+	received := requests()
+	if len(received) != 1 {
+		t.Fatalf("got %d requests, want 1", len(received))
+	}
+	want, _ := json.Marshal(event)
+	if string(received[0].body) != string(want) {
+		t.Errorf("body = %s, want %s", received[0].body, want)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(received[0].body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := received[0].header.Get(signatureHeader); got != signature {
+		t.Errorf("%s = %q, want %q", signatureHeader, got, signature)
+	}
+	if got := received[0].header.Get(eventHeader); got != string(models.ImportedEvent) {
+		t.Errorf("%s = %q, want %q", eventHeader, got, models.ImportedEvent)
+	}
+}
+
+func TestHandleFiltersEvents(t *testing.T) {
+	server, requests := recordingServer(t, http.StatusOK)
+	listener := newTestListener(t, config.WebhookEndpointConfig{Url: server.URL, Events: []models.EventType{models.FailedEvent}}, 0, "")
+
+	listener.Handle(context.Background(), testEvent())
+
+	if len(requests()) != 0 {
+		t.Errorf("got %d requests for a filtered event, want 0", len(requests()))
+	}
+}
+
+func TestHandleRetriesServerErrors(t *testing.T) {
+	server, requests := recordingServer(t, http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusOK)
+	deadLetterFile := filepath.Join(t.TempDir(), "dead.jsonl")
+	listener := newTestListener(t, config.WebhookEndpointConfig{Url: server.URL}, 5, deadLetterFile)
+
+	listener.Handle(context.Background(), testEvent())
+
+	received := requests()
+	if len(received) != 3 {
+		t.Fatalf("got %d requests, want 3", len(received))
+	}
+	// The delay doubles after every failed attempt
+	for i, want := range []time.Duration{testBackoff, 2 * testBackoff} {
+		if got := received[i+1].time.Sub(received[i].time); got < want {
+			t.Errorf("retry %d after %s, want at least %s", i+1, got, want)
+		}
+	}
+	if entries := readDeadLetters(t, deadLetterFile); len(entries) != 0 {
+		t.Errorf("got %d dead letters for a delivered event, want 0", len(entries))
+	}
+}
+
+func TestHandleWritesDeadLetter(t *testing.T) {
+	server, requests := recordingServer(t, http.StatusBadGateway)
+	deadLetterFile := filepath.Join(t.TempDir(), "dead.jsonl")
+	listener := newTestListener(t, config.WebhookEndpointConfig{Url: server.URL}, 3, deadLetterFile)
+	event := testEvent()
+
+	listener.Handle(context.Background(), event)
+	listener.Handle(context.Background(), event)
+
+	if len(requests()) != 6 {
+		t.Errorf("got %d requests, want 3 per event", len(requests()))
+	}
+	entries := readDeadLetters(t, deadLetterFile)
+	if len(entries) != 2 {
+		t.Fatalf("got %d dead letters, want one line per event", len(entries))
+	}
+	want, _ := json.Marshal(event)
+	entry := entries[0]
+	if entry.Url != server.URL || entry.Event != models.ImportedEvent || len(entry.Error) == 0 {
+		t.Errorf("dead letter = %+v, want url %s, event %s and an error", entry, server.URL, models.ImportedEvent)
+	}
+	if string(entry.Body) != string(want) {
+		t.Errorf("dead letter body = %s, want %s", entry.Body, want)
+	}
+}
+
+func TestHandleDoesNotRetryClientErrors(t *testing.T) {
+	server, requests := recordingServer(t, http.StatusBadRequest)
+	deadLetterFile := filepath.Join(t.TempDir(), "dead.jsonl")
+	listener := newTestListener(t, config.WebhookEndpointConfig{Url: server.URL}, 5, deadLetterFile)
+
+	listener.Handle(context.Background(), testEvent())
+
+	if len(requests()) != 1 {
+		t.Errorf("got %d requests, want 1", len(requests()))
+	}
+	if entries := readDeadLetters(t, deadLetterFile); len(entries) != 1 {
+		t.Errorf("got %d dead letters, want 1", len(entries))
+	}
+}
+
+func TestDropWritesDeadLetter(t *testing.T) {
+	server, requests := recordingServer(t, http.StatusOK)
+	deadLetterFile := filepath.Join(t.TempDir(), "dead.jsonl")
+	listener := newTestListener(t, config.WebhookEndpointConfig{Url: server.URL, Events: []models.EventType{models.ImportedEvent}}, 5, deadLetterFile)
+	event := testEvent()
+	filtered := testEvent()
+	filtered.Type = models.FailedEvent
+
+	listener.Drop(event)
+	listener.Drop(filtered)
+
+	if len(requests()) != 0 {
+		t.Errorf("got %d requests for dropped events, want 0", len(requests()))
+	}
+	entries := readDeadLetters(t, deadLetterFile)
+	if len(entries) != 1 {
+		t.Fatalf("got %d dead letters, want 1 for the accepted event", len(entries))
+	}
+	want, _ := json.Marshal(event)
+	if entries[0].Event != models.ImportedEvent || entries[0].Error != errQueueFull.Error() || string(entries[0].Body) != string(want) {
+		t.Errorf("dead letter = %+v, want the dropped %s event", entries[0], models.ImportedEvent)
+	}
+}
@@ -0,0 +1,80 @@
+package ytdlp
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/bongofriend/torrent-ingest/models"
+	"github.com/lrstanley/go-ytdlp"
+)
+
+// attachMetadata assigns the info dicts printed by yt-dlp to the downloaded files.
+// Files are matched by name without extension as post-processing like audio extraction changes the extension.
+func attachMetadata(files []models.JobFile, infos []*ytdlp.ExtractedInfo) {
+	byStem := map[string]*models.Metadata{}
+	for _, info := range infos {
+		filename := info.Filename
+		if info.AltFilename != nil {
+			filename = info.AltFilename
+		}
+		if filename == nil {
+			continue
+		}
+		byStem[stem(*filename)] = metadataFromInfo(info)
+	}
+	for i, f := range files {
+		if m, ok := byStem[stem(f.Source)]; ok {
+			files[i].Metadata = m
+		}
+	}
+}
+
+func metadataFromInfo(info *ytdlp.ExtractedInfo) *models.Metadata {
+	m := &models.Metadata{
+		// Music platforms report the name of the song separately from the title of the upload
+		Title:       firstOf(info.Track, info.Title),
+		Artist:      firstOf(info.Artist, info.Creator),
+		AlbumArtist: value(info.AlbumArtist),
+		Album:       value(info.Album),
+		Genre:       value(info.Genre),
+		Channel:     firstOf(info.Channel, info.Uploader),
+		Playlist:    firstOf(info.PlaylistTitle, info.Playlist),
+		Description: value(info.Description),
+		UploadDate:  firstOf(info.ReleaseDate, info.UploadDate),
+		Tags:        info.Tags,
+	}
+	if info.PlaylistIndex != nil {
+		m.PlaylistIndex = *info.PlaylistIndex
+	}
+	if info.ReleaseYear != nil {
+		m.Year = *info.ReleaseYear
+	}
+	if info.TrackNumber != nil {
+		m.Track = int(*info.TrackNumber)
+	}
+	if info.DiscNumber != nil {
+		m.Disc = int(*info.DiscNumber)
+	}
+	return m
+}
+
+func stem(p string) string {
+	base := filepath.Base(p)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+func value(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func firstOf(values ...*string) string {
+	for _, v := range values {
+		if v != nil && len(*v) > 0 {
+			return *v
+		}
+	}
+	return ""
+}
@@ -5,13 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/bongofriend/torrent-ingest/config"
+	"github.com/bongofriend/torrent-ingest/diskspace"
+	"github.com/bongofriend/torrent-ingest/events"
 	"github.com/bongofriend/torrent-ingest/models"
+	"github.com/bongofriend/torrent-ingest/postprocess"
 	"github.com/lrstanley/go-ytdlp"
-	cp "github.com/otiai10/copy"
 )
 
 var (
@@ -30,7 +34,8 @@ type AddDownloadRequest struct {
 }
 
 type YtdlpDownloadService interface {
-	QueueDownload(ctx context.Context, request AddDownloadRequest) error
+	// QueueDownload enqueues the request and returns the id of the job it is processed as
+	QueueDownload(ctx context.Context, request AddDownloadRequest) (string, error)
 }
 
 type YtdlpService interface {
@@ -40,10 +45,19 @@ type YtdlpService interface {
 
 type ytdlpCommandFunc func() *ytdlp.Command
 
+// queuedDownload is a download request together with the job it was submitted as
+type queuedDownload struct {
+	AddDownloadRequest
+	job *models.Job
+}
+
 type ytdlpService struct {
-	jobChan       chan AddDownloadRequest
+	jobChan       chan queuedDownload
 	ytdlpCommands map[models.MediaCategory]ytdlpCommandFunc
-	pathConfig    config.PathConfig
+	importer      postprocess.Importer
+	publisher     events.Publisher
+	guard         diskspace.Guard
+	categories    config.CategoriesConfig
 }
 
 func configureForMusic() *ytdlp.Command {
@@ -76,6 +90,9 @@ func configureForVideo() *ytdlp.Command {
 		EmbedChapters().
 		EmbedMetadata().
 		EmbedThumbnail().
+		// The thumbnail is kept next to the video to be used as artwork by media servers
+		WriteThumbnail().
+		ConvertThumbnails("jpg").
 		ProgressFunc(100*time.Millisecond, func(prog ytdlp.ProgressUpdate) {
 			fmt.Printf( //nolint:forbidigo
 				"%s @ %s [eta: %s] :: %s\n",
@@ -87,27 +104,69 @@ func configureForVideo() *ytdlp.Command {
 		})
 }
 
-func NewYtlDlpService(pathConfig config.PathConfig) YtdlpService {
+// withSubtitles downloads the subtitles in the preferred languages next to the video, they are handled by the subtitle stage
+func withSubtitles(cmd *ytdlp.Command, subtitles config.SubtitlesConfig) *ytdlp.Command {
+	languages := subtitles.Languages
+	if len(languages) == 0 {
+		languages = []string{"en"}
+	}
+	patterns := make([]string, len(languages))
+	for i, l := range languages {
+		// Matches regional variants like "en-US" as well
+		patterns[i] = l + ".*"
+	}
+	cmd = cmd.WriteSubs().SubLangs(strings.Join(patterns, ",")).ConvertSubs("srt")
+	if subtitles.AutoCaptions {
+		cmd = cmd.WriteAutoSubs()
+	}
+	return cmd
+}
+
+func NewYtlDlpService(importer postprocess.Importer, publisher events.Publisher, guard diskspace.Guard, categories config.CategoriesConfig) YtdlpService {
 	return ytdlpService{
-		pathConfig: pathConfig,
-		jobChan:    make(chan AddDownloadRequest, maxParallelDownloadLimit),
+		importer:   importer,
+		publisher:  publisher,
+		guard:      guard,
+		categories: categories,
+		jobChan:    make(chan queuedDownload, maxParallelDownloadLimit),
 		ytdlpCommands: map[models.MediaCategory]ytdlpCommandFunc{
-			models.Music:  configureForMusic,
-			models.Series: configureForVideo,
+			models.Music:     configureForMusic,
+			models.Audiobook: configureForMusic,
+			models.Series:    configureForVideo,
+			models.Movies:    configureForVideo,
 		},
 	}
 }
 
+// UrlTypeOf guesses whether a URL refers to a single video or a playlist
+func UrlTypeOf(rawUrl string) models.YoutubeUrlType {
+	u, err := url.Parse(rawUrl)
+	if err == nil && strings.HasSuffix(u.Path, "/playlist") {
+		return models.Playlist
+	}
+	return models.Video
+}
+
 // QueueDownload implements YtdlpyService.
-func (y ytdlpService) QueueDownload(ctx context.Context, request AddDownloadRequest) error {
+func (y ytdlpService) QueueDownload(ctx context.Context, request AddDownloadRequest) (string, error) {
+	if err := y.guard.Check(); err != nil {
+		return "", err
+	}
 	// Create a context that cancels itself after some time
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, maxDownloadEnqueTimeout)
 	defer cancel()
+	queued := queuedDownload{
+		AddDownloadRequest: request,
+		job:                models.NewJob(models.YtdlpJob, request.Url, request.Category),
+	}
+	// The job belongs to the download routine once it is sent, so it is published before to keep the events in order
+	y.publisher.Publish(models.SubmittedEvent, queued.job)
 	select {
 	case <-ctxWithTimeout.Done():
-		return ErrNotEnqueued
-	case y.jobChan <- request:
-		return nil
+		y.importer.Fail(ctx, queued.job, ErrNotEnqueued)
+		return "", ErrNotEnqueued
+	case y.jobChan <- queued:
+		return queued.job.Id, nil
 	}
 }
 
@@ -124,6 +183,10 @@ func (y ytdlpService) Start(ctx context.Context) {
 			if !ok {
 				return
 			}
+			// Queued downloads are only started once there is enough space again
+			if err := y.guard.Wait(ctx); err != nil {
+				return
+			}
 			if err := y.handleDownload(ctx, job); err != nil {
 				log.Println(err)
 				continue
@@ -132,55 +195,58 @@ func (y ytdlpService) Start(ctx context.Context) {
 	}
 }
 
-func (y ytdlpService) handleDownload(ctx context.Context, job AddDownloadRequest) error {
+func (y ytdlpService) handleDownload(ctx context.Context, job queuedDownload) error {
 	log.Printf("Downloading Yotube URL %s as %s for media category %s", job.Url, job.UrlType, job.Category)
-	workingDir, err := os.MkdirTemp("", "ytldlp*")
+	importJob := job.job
+	workingDir, infos, err := y.download(ctx, job.AddDownloadRequest)
+	if len(workingDir) > 0 {
+		defer func() {
+			os.RemoveAll(workingDir)
+		}()
+	}
 	if err != nil {
+		y.importer.Fail(ctx, importJob, err)
 		return err
 	}
-	defer func() {
-		os.RemoveAll(workingDir)
-	}()
+	if importJob.Files, err = postprocess.FilesFromDir(workingDir); err != nil {
+		y.importer.Fail(ctx, importJob, err)
+		return err
+	}
+	attachMetadata(importJob.Files, infos)
+	if err := y.importer.Import(ctx, importJob); err != nil {
+		return err
+	}
+	log.Printf("Finished downloading Yotube URL %s as %s for media category %s", job.Url, job.UrlType, job.Category)
+	return nil
+}
+
+// download runs yt-dlp for the request and returns the working directory containing the downloaded files
+// together with the info dicts printed by yt-dlp
+func (y ytdlpService) download(ctx context.Context, job AddDownloadRequest) (string, []*ytdlp.ExtractedInfo, error) {
 	commandFunc, ok := y.ytdlpCommands[job.Category]
 	if !ok {
-		return fmt.Errorf("media catefory %s not supported by ytdlp", job.Category)
+		return "", nil, fmt.Errorf("media catefory %s not supported by ytdlp", job.Category)
+	}
+	workingDir, err := os.MkdirTemp("", "ytldlp*")
+	if err != nil {
+		return "", nil, err
 	}
 	ytdlpCmd := commandFunc().
 		Paths(workingDir)
 	if job.UrlType != models.Playlist {
 		ytdlpCmd = ytdlpCmd.NoPlaylist()
 	}
-	_, err = ytdlpCmd.Run(ctx, job.Url)
+	if subtitles := y.categories.For(job.Category).Subtitles; subtitles.Download {
+		ytdlpCmd = withSubtitles(ytdlpCmd, subtitles)
+	}
+	result, err := ytdlpCmd.Run(ctx, job.Url)
 	if err != nil {
-		return err
+		return workingDir, nil, err
 	}
-	y.copyDownloads(workingDir, job)
-	log.Printf("Finished downloading Yotube URL %s as %s for media category %s", job.Url, job.UrlType, job.Category)
-	return nil
-}
-
-func (y ytdlpService) copyDownloads(downloadPath string, job AddDownloadRequest) {
-	var dest string
-	switch job.Category {
-	case models.Audiobook:
-		dest = y.pathConfig.Destinations.Audiobooks
-	case models.Anime:
-		dest = y.pathConfig.Destinations.Anime
-	case models.Series:
-		dest = y.pathConfig.Destinations.Series
-	case models.Movies:
-		dest = y.pathConfig.Destinations.Movie
-	case models.Music:
-		dest = y.pathConfig.Destinations.Music
-	default:
-		log.Printf("Unknown category %s for download %s", job.Category, job.Url)
-		return
-	}
-	if len(dest) == 0 {
-		log.Printf("No destination configured for category %s, skipping download %s", job.Category, job.Url)
-		return
-	}
-	if err := cp.Copy(downloadPath, dest); err != nil {
-		log.Print(err)
+	infos, err := result.GetExtractedInfo()
+	if err != nil {
+		// Missing metadata does not prevent the import
+		log.Printf("Reading yt-dlp metadata for %s failed: %s", job.Url, err)
 	}
+	return workingDir, infos, nil
 }